@@ -0,0 +1,468 @@
+// Package archiver archives rows of MySQL, PostgreSQL or SQLite tables older
+// than a cutoff date to a Sink, CSV files by default, and optionally deletes
+// them from the tables.
+// It is what archi runs, and can be embedded in other Go programs:
+//
+//	a := archiver.New(db)
+//	a.Logger = logger
+//	a.Sink, err = archiver.NewSink("jsonl", nil) // or one added with RegisterSink
+//
+//	result, err := a.Archive(&archiver.Config{
+//		Tables:     []archiver.Table{{Name: "orders", TimestampCol: "created_at"}},
+//		CutoffDate: time.Now().AddDate(0, -6, 0),
+//		OutputDir:  "/var/archive",
+//		Limit:      1000,
+//		Purge:      true,
+//	}, ctx)
+package archiver
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	database "github.com/fn3x/archivator/internal/db"
+	"github.com/fn3x/archivator/internal/filter"
+	"github.com/fn3x/archivator/internal/helpers"
+)
+
+// Table is a table to archive. A table without a timestamp column is
+// archived by the timestamp column of the table its RefColumn points to.
+type Table = database.Table
+
+// Transform changes a column value on its way to the archive
+type Transform = database.Transform
+
+// Filter is a condition the archived rows have to match
+type Filter = filter.Filter
+
+// ParseTransform parses a transform spec: null, hash, truncate:N or
+// const:VALUE. hash needs a salt.
+func ParseTransform(spec string, salt string) (Transform, error) {
+	return database.ParseTransform(spec, salt)
+}
+
+// ParseFilter parses a condition such as "status IN ('closed', 'cancelled')"
+func ParseFilter(expr string) (*Filter, error) {
+	return filter.Parse(expr)
+}
+
+// Config is what a run archives. The cutoff date, limit, filter and output
+// directory apply to the tables that don't set their own.
+type Config struct {
+	Tables     []Table
+	CutoffDate time.Time
+	OutputDir  string
+	Limit      int32
+	Batches    int
+	Purge      bool
+	Where      *Filter
+
+	// LockTimeout is how long to wait for tables another run is archiving,
+	// 0 fails right away and a negative value waits without a limit
+	LockTimeout time.Duration
+}
+
+func NewConfig() *Config {
+	return &Config{}
+}
+
+// Result is what a run archived. A run that failed returns what it archived
+// before the error.
+type Result struct {
+	RunID      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Tables     []TableResult
+}
+
+// TableResult is what a run archived from a table
+type TableResult struct {
+	Table      string
+	CutoffDate time.Time
+
+	// Rows is how many rows were archived and IDs their ids. Deleted is
+	// how many of them were purged.
+	Rows    int64
+	IDs     []uint64
+	Deleted int64
+
+	// Output is where the rows were written to, empty when the table was
+	// never read. Bytes is 0 for sinks that don't write bytes.
+	Output  string
+	Bytes   int64
+	Batches int
+
+	ArchiveDuration time.Duration
+	DeleteDuration  time.Duration
+}
+
+type Archiver struct {
+	DB       *sql.DB
+	Logger   *slog.Logger
+	Observer Observer
+	Sink     Sink
+}
+
+// New returns an archiver of the tables in db. It logs to slog.Default()
+// unless Logger is set and writes CSV files unless Sink is set.
+func New(db *sql.DB) *Archiver {
+	return &Archiver{DB: db}
+}
+
+// Archive archives the tables in batches of Limit rows per table. Every batch
+// is written to the sink and, with Purge, deleted from the tables with a reference
+// table first and then from the others. It stops when no table has rows left,
+// after Batches batches (0 means no limit), or when ctx is done, in which
+// case the batch in progress is finished first. The tables are locked for
+// the whole run so another run can't archive or purge them at the same time.
+func (a *Archiver) Archive(config *Config, ctx context.Context) (result *Result, err error) {
+	if err := helpers.AssertError(config.Limit > 0, "Expected rows limit to be greater than zero"); err != nil {
+		return nil, err
+	}
+
+	if err := helpers.AssertError(config.Batches >= 0, "Expected batches not to be negative"); err != nil {
+		return nil, err
+	}
+
+	tables := make([]Table, len(config.Tables))
+	for i, table := range config.Tables {
+		if err := helpers.AssertError(table.Name != "", "Expected table to have a name"); err != nil {
+			return nil, err
+		}
+
+		if slices.ContainsFunc(config.Tables[:i], func(t Table) bool { return t.Name == table.Name }) {
+			return nil, fmt.Errorf("table %s is listed more than once", table.Name)
+		}
+
+		if err := helpers.AssertError(table.Limit >= 0, "Expected table rows limit not to be negative"); err != nil {
+			return nil, err
+		}
+
+		if table.TimestampCol == "" {
+			if err := helpers.AssertError(table.RefTable != "", "Expected table with no timestamp column to have reference table name"); err != nil {
+				return nil, err
+			}
+
+			if err := helpers.AssertError(table.RefColumn != "", "Expected table with no timestamp column to have reference column name"); err != nil {
+				return nil, err
+			}
+
+			if err := helpers.AssertError(table.RefTimestampCol != "", "Expected table with no timestamp column to have reference timestamp column name"); err != nil {
+				return nil, err
+			}
+		}
+
+		tables[i] = table.WithDefaults(config.CutoffDate, config.Limit, config.Where, config.OutputDir)
+
+		if tables[i].CutoffDate.IsZero() {
+			return nil, fmt.Errorf("table %s has no cutoff date", table.Name)
+		}
+	}
+
+	logger := a.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	run := Run{
+		ID:         newRunID(),
+		StartedAt:  time.Now(),
+		CutoffDate: config.CutoffDate,
+	}
+
+	for _, table := range tables {
+		run.Tables = append(run.Tables, RunTable{Name: table.Name, CutoffDate: table.CutoffDate})
+	}
+
+	logger = logger.With("run", run.ID)
+
+	// the recorders are started before the tables are validated and the
+	// sink is opened, so the runs failing there are recorded too
+	var planners []Planner
+	var oldestRowObservers []OldestRowObserver
+	for _, o := range observersOf(a.Observer) {
+		if planner, ok := o.(Planner); ok {
+			planners = append(planners, planner)
+		}
+
+		if observer, ok := o.(OldestRowObserver); ok {
+			oldestRowObservers = append(oldestRowObservers, observer)
+		}
+
+		if recorder, ok := o.(Recorder); ok {
+			recorder.Started(run)
+			defer func() { recorder.Finished(run, err) }()
+		}
+	}
+
+	if err := database.ValidateTables(a.DB, tables); err != nil {
+		return nil, err
+	}
+
+	result = &Result{RunID: run.ID, StartedAt: run.StartedAt}
+	results := make(map[string]*TableResult, len(tables))
+
+	sink := a.Sink
+	if sink == nil {
+		sink = &fileSink{format: formats["csv"]}
+	}
+
+	writers := make(map[string]Writer, len(tables))
+	lastIds := make(map[string]uint64, len(tables))
+	done := make(map[string]bool, len(tables))
+
+	for i, table := range tables {
+		writer, err := sink.Open(ctx, SinkTable{
+			Name:         table.Name,
+			RunID:        run.ID,
+			StartedAt:    run.StartedAt,
+			CutoffDate:   table.CutoffDate,
+			OutputDir:    table.OutputDir,
+			TimestampCol: table.TimestampCol,
+			Purge:        config.Purge,
+		})
+		if err != nil {
+			for _, w := range writers {
+				w.Abort()
+			}
+
+			return result, fmt.Errorf("couldn't open the archive of %s: %v", table.Name, err)
+		}
+
+		writers[table.Name] = writer
+		run.Tables[i].Output = writer.Location()
+
+		result.Tables = append(result.Tables, TableResult{Table: table.Name, CutoffDate: table.CutoffDate})
+	}
+
+	for i := range result.Tables {
+		results[result.Tables[i].Table] = &result.Tables[i]
+	}
+
+	// registered after the recorders, so they see the errors of Commit
+	defer func() {
+		for name, writer := range writers {
+			if recorder, ok := writer.(deleteRecorder); ok {
+				recorder.recordDeleted(results[name].Deleted)
+			}
+
+			closeErr := writer.Abort
+			if err == nil {
+				closeErr = writer.Commit
+			}
+
+			if closeErr := closeErr(); closeErr != nil && err == nil {
+				err = fmt.Errorf("failed to write %s: %v", writer.Location(), closeErr)
+			}
+
+			if results[name].Batches > 0 {
+				results[name].Output = writer.Location()
+			}
+		}
+
+		for i := range run.Tables {
+			run.Tables[i].Output = writers[run.Tables[i].Name].Location()
+		}
+
+		result.FinishedAt = time.Now()
+	}()
+
+	locks, err := database.LockTables(ctx, logger, a.DB, tables, config.LockTimeout)
+	if err != nil {
+		return result, err
+	}
+	defer locks.Release()
+
+	if len(planners) > 0 {
+		for _, table := range tables {
+			count, err := database.CountRows(logger.With("table", table.Name), a.DB, table)
+			if err != nil {
+				logger.Warn("couldn't count the rows to archive", "table", table.Name, "err", err)
+				continue
+			}
+
+			if config.Batches > 0 {
+				count = min(count, int64(config.Batches)*int64(table.Limit))
+			}
+
+			logger.Info("planned rows", "table", table.Name, "rows", count)
+			for _, planner := range planners {
+				planner.Planned(table.Name, count)
+			}
+		}
+	}
+
+	for batch := 1; config.Batches == 0 || batch <= config.Batches; batch++ {
+		if ctx.Err() != nil {
+			logger.Warn("run stopped before the batch", "batch", batch, "err", ctx.Err())
+			return result, ctx.Err()
+		}
+
+		batchLogger := logger.With("batch", batch)
+		tablesIds := make(map[string][]uint64, len(tables))
+
+		for _, table := range tables {
+			if done[table.Name] {
+				continue
+			}
+
+			tableLogger := batchLogger.With("table", table.Name)
+
+			batchStartedAt := time.Now()
+
+			ids, bytes, err := a.archiveBatch(tableLogger, table, lastIds[table.Name], writers[table.Name])
+			if err != nil {
+				return result, fmt.Errorf("failed to archive %s: %v\n", table.Name, err)
+			}
+
+			duration := time.Since(batchStartedAt)
+
+			tableLogger.Info("archived rows", "rows", len(ids), "bytes", bytes, "duration", duration, "output", writers[table.Name].Location())
+
+			tableResult := results[table.Name]
+			tableResult.Rows += int64(len(ids))
+			tableResult.IDs = append(tableResult.IDs, ids...)
+			tableResult.Bytes += bytes
+			tableResult.Batches++
+			tableResult.ArchiveDuration += duration
+
+			if a.Observer != nil {
+				a.Observer.Archived(table.Name, len(ids), bytes, duration)
+			}
+
+			if len(ids) < int(table.Limit) {
+				done[table.Name] = true
+			}
+
+			if len(ids) > 0 {
+				lastIds[table.Name] = ids[len(ids)-1]
+			}
+
+			tablesIds[table.Name] = ids
+		}
+
+		if config.Purge {
+			if err := a.purgeBatch(batchLogger, tables, tablesIds, results); err != nil {
+				return result, err
+			}
+		}
+
+		if len(done) == len(tables) {
+			break
+		}
+	}
+
+	if len(oldestRowObservers) > 0 {
+		for _, table := range tables {
+			oldest, ok, err := database.OldestRow(a.DB, table)
+			if err != nil {
+				logger.Warn("couldn't read the oldest row", "table", table.Name, "err", err)
+				continue
+			}
+
+			var age time.Duration
+			if ok {
+				age = time.Since(oldest)
+			}
+
+			for _, observer := range oldestRowObservers {
+				observer.OldestRow(table.Name, age)
+			}
+		}
+	}
+
+	logger.Info("run finished", "duration", time.Since(run.StartedAt))
+
+	return result, nil
+}
+
+// archiveBatch reads the batch after afterID and writes it to out
+func (a *Archiver) archiveBatch(logger *slog.Logger, table Table, afterID uint64, out Writer) ([]uint64, int64, error) {
+	rows, err := database.SelectBatch(logger, a.DB, table, afterID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	batch, ids, err := readBatch(rows, table)
+	rows.Close()
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	bytes, err := out.Write(batch)
+	if err != nil {
+		return nil, bytes, err
+	}
+
+	return ids, bytes, nil
+}
+
+// purgeBatch deletes the archived ids, tables with a reference table first so
+// their rows are gone before the rows they point to
+func (a *Archiver) purgeBatch(logger *slog.Logger, tables []Table, tablesIds map[string][]uint64, results map[string]*TableResult) error {
+	purge := func(table Table) error {
+		ids, archived := tablesIds[table.Name]
+		if !archived {
+			return nil
+		}
+
+		tableLogger := logger.With("table", table.Name)
+
+		if len(ids) == 0 {
+			tableLogger.Info("no ids archived, not deleting rows")
+			return nil
+		}
+
+		startedAt := time.Now()
+
+		deleted, err := database.DeleteRows(tableLogger, a.DB, table, ids)
+		if err != nil {
+			return fmt.Errorf("failed to delete from %s: %v\n", table.Name, err)
+		}
+
+		duration := time.Since(startedAt)
+		tableLogger.Info("deleted rows", "rows", deleted, "duration", duration)
+
+		results[table.Name].Deleted += deleted
+		results[table.Name].DeleteDuration += duration
+
+		if a.Observer != nil {
+			a.Observer.Deleted(table.Name, deleted, duration)
+		}
+
+		return nil
+	}
+
+	for _, table := range tables {
+		if table.TimestampCol == "" {
+			if err := purge(table); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, table := range tables {
+		if table.TimestampCol != "" {
+			if err := purge(table); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// newRunID returns a short random id telling the lines of one run apart
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
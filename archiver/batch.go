@@ -0,0 +1,183 @@
+package archiver
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	database "github.com/fn3x/archivator/internal/db"
+)
+
+// Batch is a batch of rows read from a table, with only the columns the
+// table outputs and its transforms applied. Values are what the driver
+// scanned: nil, []byte, int64, float64, time.Time or a transformed value.
+type Batch struct {
+	Table   string
+	Columns []string
+	Rows    [][]any
+}
+
+// readBatch reads the rows into a batch and returns the ids of the rows
+func readBatch(rows *sql.Rows, table Table) (*Batch, []uint64, error) {
+	ids := make([]uint64, 0, table.Limit)
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, ids, err
+	}
+
+	batch := &Batch{Table: table.Name, Rows: make([][]any, 0, table.Limit)}
+	for _, column := range columns {
+		if table.Outputs(column) {
+			batch.Columns = append(batch.Columns, column)
+		}
+	}
+
+	for rows.Next() {
+		values := make([]any, len(columns))
+		valuePtrs := make([]any, len(columns))
+
+		for i := range columns {
+			valuePtrs[i] = &values[i]
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, ids, err
+		}
+
+		row := make([]any, 0, len(batch.Columns))
+		for i, val := range values {
+			if columns[i] == "id" {
+				if id, ok := database.IDValue(val); ok {
+					ids = append(ids, id)
+				}
+			}
+
+			if !table.Outputs(columns[i]) {
+				continue
+			}
+
+			if transform, ok := table.Transforms[columns[i]]; ok {
+				val = transform(val)
+			}
+
+			row = append(row, val)
+		}
+
+		batch.Rows = append(batch.Rows, row)
+	}
+
+	return batch, ids, rows.Err()
+}
+
+// format encodes batches for the sinks writing bytes
+type format struct {
+	extension   string
+	contentType string
+	// encode writes the batch, header tells whether it starts a new file
+	encode func(w io.Writer, batch *Batch, header bool) error
+}
+
+var formats = map[string]format{
+	"csv":   {extension: ".csv", contentType: "text/csv", encode: encodeCSV},
+	"jsonl": {extension: ".jsonl", contentType: "application/x-ndjson", encode: encodeJSONL},
+}
+
+func lookupFormat(name string) (format, error) {
+	if name == "" {
+		name = "csv"
+	}
+
+	f, ok := formats[name]
+	if !ok {
+		return format{}, fmt.Errorf("unknown format %q, expected csv or jsonl", name)
+	}
+
+	return f, nil
+}
+
+func encodeCSV(w io.Writer, batch *Batch, header bool) error {
+	writer := csv.NewWriter(w)
+
+	if header {
+		writer.Write(batch.Columns)
+	}
+
+	record := make([]string, len(batch.Columns))
+	for _, row := range batch.Rows {
+		for i, val := range row {
+			record[i] = formatValue(val)
+		}
+
+		writer.Write(record)
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// encodeJSONL writes every row as a JSON object with the columns in the
+// order of the table
+func encodeJSONL(w io.Writer, batch *Batch, header bool) error {
+	out := bufio.NewWriter(w)
+
+	keys := make([][]byte, len(batch.Columns))
+	for i, column := range batch.Columns {
+		key, err := json.Marshal(column)
+		if err != nil {
+			return err
+		}
+
+		keys[i] = key
+	}
+
+	for _, row := range batch.Rows {
+		out.WriteByte('{')
+
+		for i, val := range row {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+
+			value, err := json.Marshal(jsonValue(val))
+			if err != nil {
+				return fmt.Errorf("column %s: %v", batch.Columns[i], err)
+			}
+
+			out.Write(keys[i])
+			out.WriteByte(':')
+			out.Write(value)
+		}
+
+		out.WriteString("}\n")
+	}
+
+	return out.Flush()
+}
+
+func formatValue(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func jsonValue(val any) any {
+	switch v := val.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return v
+	}
+}
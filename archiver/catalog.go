@@ -0,0 +1,363 @@
+package archiver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/fn3x/archivator/internal/db"
+)
+
+// CatalogFile is the name of the catalog in an output directory
+const CatalogFile = "archi-catalog.json"
+
+// catalogMu serializes the updates of catalogs within the process, the lock
+// file next to a catalog those of the processes sharing its directory
+var catalogMu sync.Mutex
+
+// Catalog indexes the archive files of an output directory, so the file
+// holding a row can be found without reading them all
+type Catalog struct {
+	Archives []CatalogEntry `json:"archives"`
+}
+
+// CatalogEntry describes an archive file
+type CatalogEntry struct {
+	// File is the name of the archive in the directory of the catalog
+	File       string    `json:"file"`
+	Table      string    `json:"table"`
+	RunID      string    `json:"run,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	CutoffDate time.Time `json:"cutoff"`
+	Rows       int64     `json:"rows"`
+	Bytes      int64     `json:"bytes"`
+	// Checksum is the hex encoded SHA-256 of the file
+	Checksum string `json:"sha256"`
+	// IDs is nil when the archive has no id column
+	IDs *IDRange `json:"ids,omitempty"`
+	// TimestampCol is the column the rows were archived by, empty for a
+	// table archived by a reference. Timestamps is nil without one.
+	TimestampCol string     `json:"timestampCol,omitempty"`
+	Timestamps   *TimeRange `json:"timestamps,omitempty"`
+	// Purged tells whether the run deleted the archived rows from the source
+	// and Deleted how many. Rows that changed after they were archived, so
+	// they no longer match the cutoff or where, are kept in the source.
+	Purged  bool  `json:"purged,omitempty"`
+	Deleted int64 `json:"deleted,omitempty"`
+	// Hold is why the archive is under legal hold, which keeps archi prune
+	// from removing it
+	Hold string `json:"hold,omitempty"`
+}
+
+// IDRange is the lowest and highest id of an archive
+type IDRange struct {
+	Min uint64 `json:"min"`
+	Max uint64 `json:"max"`
+}
+
+// TimeRange is the oldest and newest timestamp of an archive
+type TimeRange struct {
+	Oldest time.Time `json:"oldest"`
+	Newest time.Time `json:"newest"`
+}
+
+// CatalogFilter narrows the archives of a catalog down. Zero values match
+// every archive.
+type CatalogFilter struct {
+	Table string
+	// ID is a row the archives have to hold, 0 for any
+	ID uint64
+	// Since and Until are the range of timestamps the rows of the archives
+	// have to overlap
+	Since time.Time
+	Until time.Time
+}
+
+// LoadCatalog reads the catalog of the directory, an empty one when there is
+// none yet
+func LoadCatalog(dir string) (*Catalog, error) {
+	content, err := os.ReadFile(filepath.Join(dir, CatalogFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Catalog{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &Catalog{}
+	if err := json.Unmarshal(content, catalog); err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, CatalogFile), err)
+	}
+
+	return catalog, nil
+}
+
+// UpdateCatalog loads the catalog of the directory, lets update change it and
+// saves it unless update fails
+func UpdateCatalog(dir string, update func(catalog *Catalog) error) error {
+	return updateCatalog(dir, update, nil)
+}
+
+// updateCatalog is UpdateCatalog calling saved, when it isn't nil, once the
+// catalog is saved and while it is still locked
+func updateCatalog(dir string, update func(catalog *Catalog) error, saved func(catalog *Catalog) error) error {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	unlock, err := lockCatalog(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	catalog, err := LoadCatalog(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := update(catalog); err != nil {
+		return err
+	}
+
+	if err := catalog.save(dir); err != nil {
+		return err
+	}
+
+	if saved == nil {
+		return nil
+	}
+
+	return saved(catalog)
+}
+
+// save writes the catalog to a temporary file and syncs it before it replaces
+// the catalog, so readers never see half of it and a crash doesn't leave an
+// empty one
+func (c *Catalog) save(dir string) error {
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, CatalogFile+".*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(content, '\n')); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), filepath.Join(dir, CatalogFile))
+}
+
+// Put adds the entry, replacing the one of the same file
+func (c *Catalog) Put(entry CatalogEntry) {
+	c.Archives = slices.DeleteFunc(c.Archives, func(e CatalogEntry) bool { return e.File == entry.File })
+	c.Archives = append(c.Archives, entry)
+
+	slices.SortStableFunc(c.Archives, func(a, b CatalogEntry) int {
+		if n := a.StartedAt.Compare(b.StartedAt); n != 0 {
+			return n
+		}
+
+		return strings.Compare(a.File, b.File)
+	})
+}
+
+// Remove drops the entry of the file and tells whether there was one
+func (c *Catalog) Remove(file string) bool {
+	n := len(c.Archives)
+	c.Archives = slices.DeleteFunc(c.Archives, func(e CatalogEntry) bool { return e.File == file })
+
+	return len(c.Archives) < n
+}
+
+// Entry returns the entry of the file
+func (c *Catalog) Entry(file string) (CatalogEntry, bool) {
+	i := slices.IndexFunc(c.Archives, func(e CatalogEntry) bool { return e.File == file })
+	if i < 0 {
+		return CatalogEntry{}, false
+	}
+
+	return c.Archives[i], true
+}
+
+// Find returns the entries matching the filter, the oldest run first
+func (c *Catalog) Find(filter CatalogFilter) []CatalogEntry {
+	var found []CatalogEntry
+
+	for _, entry := range c.Archives {
+		if entry.matches(filter) {
+			found = append(found, entry)
+		}
+	}
+
+	return found
+}
+
+// matches tells whether the entry matches the filter. Without a timestamp
+// range all rows of an archive are only known to be older than its cutoff.
+func (e CatalogEntry) matches(filter CatalogFilter) bool {
+	if filter.Table != "" && e.Table != filter.Table {
+		return false
+	}
+
+	if filter.ID != 0 && (e.IDs == nil || filter.ID < e.IDs.Min || filter.ID > e.IDs.Max) {
+		return false
+	}
+
+	if e.Timestamps == nil {
+		return filter.Since.IsZero() || filter.Since.Before(e.CutoffDate)
+	}
+
+	if !filter.Since.IsZero() && e.Timestamps.Newest.Before(filter.Since) {
+		return false
+	}
+
+	return filter.Until.IsZero() || e.Timestamps.Oldest.Before(filter.Until)
+}
+
+// add counts the rows of the batch into the entry
+func (e *CatalogEntry) add(batch *Batch) {
+	idColumn := slices.Index(batch.Columns, "id")
+
+	timestampColumn := -1
+	if e.TimestampCol != "" {
+		timestampColumn = slices.Index(batch.Columns, e.TimestampCol)
+	}
+
+	for _, row := range batch.Rows {
+		e.Rows++
+
+		if idColumn >= 0 {
+			if id, ok := database.IDValue(row[idColumn]); ok {
+				e.addID(id)
+			}
+		}
+
+		if timestampColumn >= 0 {
+			if t, ok, err := database.TimestampValue(row[timestampColumn]); ok && err == nil {
+				e.addTimestamp(t)
+			}
+		}
+	}
+}
+
+func (e *CatalogEntry) addID(id uint64) {
+	if e.IDs == nil {
+		e.IDs = &IDRange{Min: id, Max: id}
+		return
+	}
+
+	e.IDs.Min = min(e.IDs.Min, id)
+	e.IDs.Max = max(e.IDs.Max, id)
+}
+
+func (e *CatalogEntry) addTimestamp(t time.Time) {
+	if e.Timestamps == nil {
+		e.Timestamps = &TimeRange{Oldest: t, Newest: t}
+		return
+	}
+
+	if t.Before(e.Timestamps.Oldest) {
+		e.Timestamps.Oldest = t
+	}
+
+	if t.After(e.Timestamps.Newest) {
+		e.Timestamps.Newest = t
+	}
+}
+
+// IndexArchive reads an archive file named by a file sink into a catalog
+// entry, for archives written before the catalog was. The file doesn't tell
+// the column its rows were archived by, so the timestamp range is only read
+// when timestampCol is set.
+func IndexArchive(path string, timestampCol string) (CatalogEntry, error) {
+	table, ok := ParseArchiveName(path)
+	if !ok {
+		return CatalogEntry{}, fmt.Errorf("%s isn't named like an archive", path)
+	}
+
+	entry := CatalogEntry{
+		File:         filepath.Base(path),
+		Table:        table.Name,
+		StartedAt:    table.StartedAt,
+		CutoffDate:   table.CutoffDate,
+		TimestampCol: timestampCol,
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return CatalogEntry{}, err
+	}
+
+	entry.Bytes = info.Size()
+
+	if entry.Checksum, err = FileChecksum(path); err != nil {
+		return CatalogEntry{}, err
+	}
+
+	err = readArchive(path, func(columns []string, row []any) error {
+		entry.Rows++
+
+		if i := slices.Index(columns, "id"); i >= 0 {
+			id, err := archiveID(row[i])
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+
+			entry.addID(id)
+		}
+
+		if i := slices.Index(columns, timestampCol); timestampCol != "" && i >= 0 {
+			if t, ok, err := database.TimestampValue(row[i]); ok && err == nil {
+				entry.addTimestamp(t)
+			}
+		}
+
+		return nil
+	})
+
+	return entry, err
+}
+
+// FileChecksum returns the hex encoded SHA-256 of the file
+func FileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package archiver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockCatalog takes an exclusive lock on the lock file of the catalog of the
+// directory, waiting for other processes holding it, and returns the function
+// releasing it
+func lockCatalog(dir string) (func(), error) {
+	path := filepath.Join(dir, CatalogFile+".lock")
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("couldn't lock %s: %v", path, err)
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,45 @@
+//go:build unix
+
+package archiver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockCatalogExcludesOtherHolders(t *testing.T) {
+	dir := t.TempDir()
+
+	unlock, err := lockCatalog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flock locks belong to the open file, so a second one conflicts with
+	// the first within the process just like in another process
+	locked := make(chan func())
+	go func() {
+		unlock, err := lockCatalog(dir)
+		if err != nil {
+			t.Error(err)
+			unlock = func() {}
+		}
+
+		locked <- unlock
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("the catalog was locked twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+
+	select {
+	case unlock := <-locked:
+		unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the catalog wasn't locked after it was released")
+	}
+}
@@ -0,0 +1,77 @@
+package archiver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestUpdateCatalogKeepsEveryUpdate(t *testing.T) {
+	dir := t.TempDir()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := UpdateCatalog(dir, func(catalog *Catalog) error {
+				catalog.Put(CatalogEntry{File: fmt.Sprintf("archived_%d.csv", i), Table: "orders"})
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	catalog, err := LoadCatalog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(catalog.Archives) != 20 {
+		t.Errorf("catalog has %d archives, want 20", len(catalog.Archives))
+	}
+
+	temporary, err := filepath.Glob(filepath.Join(dir, CatalogFile+".*[0-9]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(temporary) > 0 {
+		t.Errorf("temporary catalogs left behind: %v", temporary)
+	}
+}
+
+func TestUpdateCatalogKeepsTheCatalogWhenUpdateFails(t *testing.T) {
+	dir := t.TempDir()
+
+	err := UpdateCatalog(dir, func(catalog *Catalog) error {
+		catalog.Put(CatalogEntry{File: "archived_1.csv", Table: "orders"})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = UpdateCatalog(dir, func(catalog *Catalog) error {
+		catalog.Remove("archived_1.csv")
+		return os.ErrPermission
+	})
+	if err != os.ErrPermission {
+		t.Fatalf("UpdateCatalog = %v, want the error of update", err)
+	}
+
+	catalog, err := LoadCatalog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := catalog.Entry("archived_1.csv"); !ok {
+		t.Error("the failed update was saved")
+	}
+}
@@ -0,0 +1,152 @@
+package archiver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	database "github.com/fn3x/archivator/internal/db"
+)
+
+// databaseSink inserts the rows into a table of the same name, with an
+// optional prefix, in another database. The tables have to exist, except on
+// SQLite where missing ones are created.
+//
+// Options: dsn (required), engine (mysql, postgres or sqlite, default mysql)
+// and prefix.
+type databaseSink struct {
+	db     *sql.DB
+	dbName string
+	prefix string
+}
+
+func newDatabaseSink(options map[string]string) (Sink, error) {
+	if options["dsn"] == "" {
+		return nil, fmt.Errorf("dsn option is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	db, err := database.Connect(options["engine"], options["dsn"], ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to the destination: %v", err)
+	}
+
+	return NewDatabaseSink(db, database.DatabaseName(options["engine"], options["dsn"]), options["prefix"]), nil
+}
+
+// NewDatabaseSink returns a sink inserting into the tables of db named after
+// the archived tables with the prefix. dbName is only used to tell where the
+// rows went. Close closes db.
+func NewDatabaseSink(db *sql.DB, dbName string, prefix string) Sink {
+	return &databaseSink{db: db, dbName: dbName, prefix: prefix}
+}
+
+func (s *databaseSink) Open(ctx context.Context, table SinkTable) (Writer, error) {
+	return &databaseWriter{sink: s, table: s.prefix + table.Name}, nil
+}
+
+func (s *databaseSink) Close() error {
+	return s.db.Close()
+}
+
+type databaseWriter struct {
+	sink    *databaseSink
+	table   string
+	created bool
+}
+
+// Write replaces the rows on MySQL and SQLite and leaves them on PostgreSQL,
+// so a batch that was archived but not purged can be archived again by the
+// next run
+func (w *databaseWriter) Write(batch *Batch) (int64, error) {
+	if len(batch.Rows) == 0 || len(batch.Columns) == 0 {
+		return 0, nil
+	}
+
+	dialect := database.DialectOf(w.sink.db)
+	chunk := max(dialect.MaxParams()/len(batch.Columns), 1)
+
+	tx, err := w.sink.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if create := dialect.CreateTable(w.table, batch.Columns); create != "" && !w.created {
+		if _, err := tx.Exec(create); err != nil {
+			return 0, fmt.Errorf("couldn't create %s: %v", w.Location(), err)
+		}
+	}
+
+	// SQLite keeps bytes as blobs, so the text MySQL returns as bytes is
+	// stored as text to be queried as such
+	sqlite := dialect.Name() == database.SQLite
+
+	columns := make([]string, len(batch.Columns))
+	for i, column := range batch.Columns {
+		columns[i] = dialect.QuoteIdent(column)
+	}
+
+	for start := 0; start < len(batch.Rows); start += chunk {
+		builder := dialect.Upsert(dialect.QuoteIdent(w.table), columns)
+
+		for _, row := range batch.Rows[start:min(start+chunk, len(batch.Rows))] {
+			if sqlite {
+				row = textValues(row)
+			}
+
+			builder = builder.Values(row...)
+		}
+
+		query, args, err := builder.ToSql()
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := tx.Exec(query, args...); err != nil {
+			return 0, fmt.Errorf("couldn't insert into %s: %v", w.Location(), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	w.created = true
+
+	return 0, nil
+}
+
+// textValues returns the row with the bytes that are valid UTF-8 as strings
+func textValues(row []any) []any {
+	values := make([]any, len(row))
+	for i, value := range row {
+		if b, ok := value.([]byte); ok && utf8.Valid(b) {
+			value = string(b)
+		}
+
+		values[i] = value
+	}
+
+	return values
+}
+
+func (w *databaseWriter) Commit() error {
+	return nil
+}
+
+func (w *databaseWriter) Abort() error {
+	return nil
+}
+
+func (w *databaseWriter) Location() string {
+	if w.sink.dbName == "" {
+		return w.table
+	}
+
+	return w.sink.dbName + "." + w.table
+}
@@ -0,0 +1,154 @@
+package archiver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileSink writes every table of a run to a file in the output directory of
+// the table: "csv" or "jsonl". The files are added to the catalog of the
+// directory once they are closed.
+type fileSink struct {
+	format format
+}
+
+func newFileSinkFactory(name string) SinkFactory {
+	return func(options map[string]string) (Sink, error) {
+		f, err := lookupFormat(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return &fileSink{format: f}, nil
+	}
+}
+
+func (s *fileSink) Open(ctx context.Context, table SinkTable) (Writer, error) {
+	path := filepath.Join(table.OutputDir, table.BaseName()+s.format.extension)
+
+	return &fileWriter{
+		path:   path,
+		format: s.format,
+		sync:   table.Purge,
+		entry: CatalogEntry{
+			File:         filepath.Base(path),
+			Table:        table.Name,
+			RunID:        table.RunID,
+			StartedAt:    table.StartedAt.UTC().Truncate(time.Second),
+			CutoffDate:   table.CutoffDate,
+			TimestampCol: table.TimestampCol,
+			Purged:       table.Purge,
+		},
+	}, nil
+}
+
+func (s *fileSink) Close() error {
+	return nil
+}
+
+// fileWriter is created by the first batch and the following batches are
+// appended to it. When the run purges, every batch is synced to disk before
+// Write returns, so rows aren't deleted while they are only in the page cache.
+type fileWriter struct {
+	path   string
+	format format
+	sync   bool
+	file   *os.File
+	out    *countingWriter
+	hash   hash.Hash
+	entry  CatalogEntry
+}
+
+func (w *fileWriter) Write(batch *Batch) (int64, error) {
+	header := false
+
+	if w.file == nil {
+		file, err := os.Create(w.path)
+		if err != nil {
+			return 0, err
+		}
+
+		w.file = file
+		w.hash = sha256.New()
+		w.out = &countingWriter{w: io.MultiWriter(file, w.hash)}
+		header = true
+	}
+
+	written := w.out.n
+	if err := w.format.encode(w.out, batch, header); err != nil {
+		return w.out.n - written, err
+	}
+
+	if w.sync {
+		if err := w.file.Sync(); err != nil {
+			return w.out.n - written, fmt.Errorf("couldn't sync %s: %v", w.path, err)
+		}
+	}
+
+	w.entry.add(batch)
+
+	return w.out.n - written, nil
+}
+
+func (w *fileWriter) Commit() error {
+	return w.close()
+}
+
+// Abort keeps the file, the rows in it may already be purged
+func (w *fileWriter) Abort() error {
+	return w.close()
+}
+
+func (w *fileWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	if err != nil {
+		return err
+	}
+
+	w.entry.Bytes = w.out.n
+	w.entry.Checksum = hex.EncodeToString(w.hash.Sum(nil))
+
+	err = UpdateCatalog(filepath.Dir(w.path), func(catalog *Catalog) error {
+		catalog.Put(w.entry)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't add the archive to the catalog: %v", err)
+	}
+
+	return nil
+}
+
+func (w *fileWriter) recordDeleted(deleted int64) {
+	w.entry.Deleted = deleted
+}
+
+func (w *fileWriter) Location() string {
+	return w.path
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,92 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileWriterCatalogsTheArchive(t *testing.T) {
+	for _, purge := range []bool{false, true} {
+		name := "keep"
+		if purge {
+			name = "purge"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			sink, err := NewSink("csv", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			writer, err := sink.Open(context.Background(), SinkTable{
+				Name:         "orders",
+				RunID:        "run",
+				StartedAt:    time.Date(2025, time.July, 1, 0, 0, 0, 0, time.UTC),
+				CutoffDate:   time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+				OutputDir:    dir,
+				TimestampCol: "created_at",
+				Purge:        purge,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if w := writer.(*fileWriter); w.sync != purge {
+				t.Errorf("sync = %v, want %v", w.sync, purge)
+			}
+
+			batches := []*Batch{
+				{Table: "orders", Columns: []string{"id", "created_at"}, Rows: [][]any{{int64(1), "2024-01-01 00:00:00"}, {int64(2), "2024-02-01 00:00:00"}}},
+				{Table: "orders", Columns: []string{"id", "created_at"}, Rows: [][]any{{int64(5), "2024-03-01 00:00:00"}}},
+			}
+
+			for _, batch := range batches {
+				if _, err := writer.Write(batch); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			writer.(deleteRecorder).recordDeleted(2)
+
+			if err := writer.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := os.ReadFile(writer.Location())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := "id,created_at\n1,2024-01-01 00:00:00\n2,2024-02-01 00:00:00\n5,2024-03-01 00:00:00\n"
+			if string(content) != want {
+				t.Errorf("archive = %q, want %q", content, want)
+			}
+
+			catalog, err := LoadCatalog(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entry, ok := catalog.Entry("archived_orders_till_2025-01-01T00:00:00Z_at_2025-07-01T00:00:00Z.csv")
+			if !ok {
+				t.Fatalf("the archive isn't in the catalog: %+v", catalog)
+			}
+
+			if entry.Rows != 3 || entry.Purged != purge || entry.Deleted != 2 || entry.Bytes != int64(len(want)) {
+				t.Errorf("entry = %+v", entry)
+			}
+
+			if entry.IDs == nil || entry.IDs.Min != 1 || entry.IDs.Max != 5 {
+				t.Errorf("ids = %+v, want 1..5", entry.IDs)
+			}
+
+			if checksum, _ := FileChecksum(writer.Location()); entry.Checksum != checksum {
+				t.Errorf("checksum = %s, want %s", entry.Checksum, checksum)
+			}
+		})
+	}
+}
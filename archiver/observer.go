@@ -0,0 +1,101 @@
+package archiver
+
+import "time"
+
+// Observer is told about the progress of an archive run. Its methods are
+// called from the goroutine running the archive.
+type Observer interface {
+	// Archived is called after a batch of rows of the table was written out
+	Archived(table string, rows int, bytes int64, duration time.Duration)
+	// Deleted is called after a batch of rows of the table was purged
+	Deleted(table string, rows int64, duration time.Duration)
+}
+
+// Planner is an Observer that wants to know how many rows the run is going to
+// archive from every table. The rows are counted before the first batch only
+// when the observer is a Planner, as counting can be slow on large tables.
+type Planner interface {
+	Observer
+	Planned(table string, rows int64)
+}
+
+// OldestRowObserver is an Observer that wants to know the age of the oldest
+// row left in every table at the end of a successful run, or 0 when the table
+// has none. The oldest rows are looked up only when the observer is an
+// OldestRowObserver, as it takes a scan of every table.
+type OldestRowObserver interface {
+	Observer
+	OldestRow(table string, age time.Duration)
+}
+
+// Recorder is an Observer that wants to know about the run as a whole.
+// Started is called before the tables are validated and the sink is opened,
+// so the outputs of the tables are still empty. Finished is called once for
+// every run Started was called for, with the outputs of the tables as they
+// were when their writers were closed.
+type Recorder interface {
+	Observer
+	Started(run Run)
+	Finished(run Run, err error)
+}
+
+// Run describes an archive run to a Recorder
+type Run struct {
+	ID         string
+	StartedAt  time.Time
+	CutoffDate time.Time
+	Tables     []RunTable
+}
+
+type RunTable struct {
+	Name       string
+	CutoffDate time.Time
+	Output     string
+}
+
+// Observers combines observers into one. Nil observers are left out.
+func Observers(observers ...Observer) Observer {
+	var m multiObserver
+
+	for _, o := range observers {
+		if o != nil {
+			m = append(m, observersOf(o)...)
+		}
+	}
+
+	switch len(m) {
+	case 0:
+		return nil
+	case 1:
+		return m[0]
+	}
+
+	return m
+}
+
+// observersOf returns the observers combined into o
+func observersOf(o Observer) []Observer {
+	if m, ok := o.(multiObserver); ok {
+		return m
+	}
+
+	if o == nil {
+		return nil
+	}
+
+	return []Observer{o}
+}
+
+type multiObserver []Observer
+
+func (m multiObserver) Archived(table string, rows int, bytes int64, duration time.Duration) {
+	for _, o := range m {
+		o.Archived(table, rows, bytes, duration)
+	}
+}
+
+func (m multiObserver) Deleted(table string, rows int64, duration time.Duration) {
+	for _, o := range m {
+		o.Deleted(table, rows, duration)
+	}
+}
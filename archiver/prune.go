@@ -0,0 +1,130 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Newest is the newest row the archive can hold: the newest timestamp or,
+// without one, the cutoff of its run
+func (e CatalogEntry) Newest() time.Time {
+	if e.Timestamps != nil {
+		return e.Timestamps.Newest
+	}
+
+	return e.CutoffDate
+}
+
+// Expired tells whether all rows of the archive are older than the cutoff
+func (e CatalogEntry) Expired(cutoffDate time.Time) bool {
+	return e.Newest().Before(cutoffDate)
+}
+
+// PruneArchive removes the archive file from dir and its catalog, or moves it
+// to moveTo and its catalog when moveTo isn't empty. It refuses archives
+// under legal hold. The archive is dropped from the catalog before the file
+// is touched, so the catalog never lists a file that is gone; it is put back
+// when the file can't be removed. An archive whose file is gone is only
+// dropped from the catalog.
+func PruneArchive(dir string, file string, moveTo string) error {
+	var pruned CatalogEntry
+	gone := false
+
+	err := updateCatalog(dir, func(catalog *Catalog) error {
+		entry, ok := catalog.Entry(file)
+		if !ok {
+			return fmt.Errorf("%s is not in the catalog of %s", file, dir)
+		}
+
+		if entry.Hold != "" {
+			return fmt.Errorf("%s is under legal hold: %s", file, entry.Hold)
+		}
+
+		catalog.Remove(file)
+		pruned = entry
+
+		return nil
+	}, func(catalog *Catalog) error {
+		path := filepath.Join(dir, file)
+
+		var err error
+		if moveTo == "" {
+			err = os.Remove(path)
+		} else {
+			err = moveFile(path, filepath.Join(moveTo, file))
+		}
+
+		gone = errors.Is(err, os.ErrNotExist)
+		if err == nil || gone {
+			return nil
+		}
+
+		catalog.Put(pruned)
+		if saveErr := catalog.save(dir); saveErr != nil {
+			return fmt.Errorf("%v, and the file is missing from the catalog: %v", err, saveErr)
+		}
+
+		return err
+	})
+	if err != nil || moveTo == "" || gone {
+		return err
+	}
+
+	err = UpdateCatalog(moveTo, func(catalog *Catalog) error {
+		catalog.Put(pruned)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("moved %s, but couldn't add it to the catalog of %s: %v", file, moveTo, err)
+	}
+
+	return nil
+}
+
+// moveFile renames the file, or copies and removes it when the target is on
+// another file system
+func moveFile(from string, to string) error {
+	if _, err := os.Stat(to); err == nil {
+		return fmt.Errorf("%s already exists", to)
+	}
+
+	err := os.Rename(from, to)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(to)
+		return err
+	}
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(to)
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+
+	return os.Remove(from)
+}
@@ -0,0 +1,132 @@
+package archiver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeArchive writes an archive file to dir and adds it to the catalog
+func writeArchive(t *testing.T, dir string, entry CatalogEntry) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, entry.File), []byte("id\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := UpdateCatalog(dir, func(catalog *Catalog) error {
+		catalog.Put(entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cataloged(t *testing.T, dir string, file string) bool {
+	t.Helper()
+
+	catalog, err := LoadCatalog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok := catalog.Entry(file)
+	return ok
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestPruneArchive(t *testing.T) {
+	dir := t.TempDir()
+	writeArchive(t, dir, CatalogEntry{File: "archived_1.csv", Table: "orders"})
+
+	if err := PruneArchive(dir, "archived_1.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists(filepath.Join(dir, "archived_1.csv")) {
+		t.Error("the file wasn't removed")
+	}
+
+	if cataloged(t, dir, "archived_1.csv") {
+		t.Error("the archive is still in the catalog")
+	}
+}
+
+func TestPruneArchiveMoves(t *testing.T) {
+	dir, moveTo := t.TempDir(), t.TempDir()
+	writeArchive(t, dir, CatalogEntry{File: "archived_1.csv", Table: "orders", Rows: 1})
+
+	if err := PruneArchive(dir, "archived_1.csv", moveTo); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists(filepath.Join(dir, "archived_1.csv")) || !exists(filepath.Join(moveTo, "archived_1.csv")) {
+		t.Error("the file wasn't moved")
+	}
+
+	if cataloged(t, dir, "archived_1.csv") || !cataloged(t, moveTo, "archived_1.csv") {
+		t.Error("the archive wasn't moved to the catalog of the target")
+	}
+}
+
+func TestPruneArchiveRefusesHolds(t *testing.T) {
+	dir := t.TempDir()
+	writeArchive(t, dir, CatalogEntry{File: "archived_1.csv", Table: "orders", Hold: "case 42"})
+
+	err := PruneArchive(dir, "archived_1.csv", "")
+	if err == nil || !strings.Contains(err.Error(), "case 42") {
+		t.Fatalf("PruneArchive = %v, want the hold", err)
+	}
+
+	if !exists(filepath.Join(dir, "archived_1.csv")) || !cataloged(t, dir, "archived_1.csv") {
+		t.Error("an archive under legal hold was pruned")
+	}
+}
+
+func TestPruneArchiveDropsMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeArchive(t, dir, CatalogEntry{File: "archived_1.csv", Table: "orders"})
+
+	if err := os.Remove(filepath.Join(dir, "archived_1.csv")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PruneArchive(dir, "archived_1.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if cataloged(t, dir, "archived_1.csv") {
+		t.Error("the archive is still in the catalog")
+	}
+}
+
+func TestPruneArchiveKeepsTheEntryOfAFileItCantRemove(t *testing.T) {
+	dir := t.TempDir()
+	writeArchive(t, dir, CatalogEntry{File: "archived_1.csv", Table: "orders"})
+
+	// a directory that isn't empty can't be removed
+	path := filepath.Join(dir, "archived_1.csv")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(path, "busy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := PruneArchive(dir, "archived_1.csv", "")
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("PruneArchive = %v, want the error of the removal", err)
+	}
+
+	if !cataloged(t, dir, "archived_1.csv") {
+		t.Error("the archive was dropped from the catalog, but its file is kept")
+	}
+}
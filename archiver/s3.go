@@ -0,0 +1,310 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// s3Sink uploads every batch as an object to an S3 compatible object storage.
+// An object per batch means the rows are stored before they are purged; the
+// objects of a table share the base name and are numbered:
+//
+//	<prefix>archived_orders_till_..._at_....00001.csv
+//
+// Options: bucket (required), prefix, region (default AWS_REGION or
+// us-east-1), endpoint for storages other than AWS, which are addressed by
+// path, format (csv or jsonl) and accesskey, secretkey and sessiontoken,
+// which default to AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+type s3Sink struct {
+	bucket       string
+	prefix       string
+	region       string
+	endpoint     *url.URL
+	format       format
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func newS3Sink(options map[string]string) (Sink, error) {
+	s := &s3Sink{
+		bucket:       options["bucket"],
+		prefix:       options["prefix"],
+		region:       optionOrEnv(options, "region", "AWS_REGION"),
+		accessKey:    optionOrEnv(options, "accesskey", "AWS_ACCESS_KEY_ID"),
+		secretKey:    optionOrEnv(options, "secretkey", "AWS_SECRET_ACCESS_KEY"),
+		sessionToken: optionOrEnv(options, "sessiontoken", "AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: 5 * time.Minute},
+	}
+
+	if s.bucket == "" {
+		return nil, fmt.Errorf("bucket option is required")
+	}
+
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("accesskey and secretkey options or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+
+	var err error
+	if s.format, err = lookupFormat(options["format"]); err != nil {
+		return nil, err
+	}
+
+	if endpoint := options["endpoint"]; endpoint != "" {
+		if s.endpoint, err = url.Parse(endpoint); err != nil || s.endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint %q, expected a URL such as https://minio:9000", endpoint)
+		}
+	}
+
+	return s, nil
+}
+
+func optionOrEnv(options map[string]string, option string, env string) string {
+	if value := options[option]; value != "" {
+		return value
+	}
+
+	return os.Getenv(env)
+}
+
+func (s *s3Sink) Open(ctx context.Context, table SinkTable) (Writer, error) {
+	// a batch in progress is finished when the run is cancelled, so the
+	// uploads don't stop with ctx
+	return &s3Writer{
+		sink: s,
+		ctx:  context.WithoutCancel(ctx),
+		key:  s.prefix + table.BaseName(),
+	}, nil
+}
+
+func (s *s3Sink) Close() error {
+	return nil
+}
+
+// objectURL returns the URL of the object with the key escaped the way the
+// signature expects it
+func (s *s3Sink) objectURL(key string) *url.URL {
+	escaped := make([]string, 0)
+	for segment := range strings.SplitSeq(key, "/") {
+		escaped = append(escaped, escapeS3(segment))
+	}
+
+	u := &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", s.bucket, s.region)}
+	path := "/" + key
+	rawPath := "/" + strings.Join(escaped, "/")
+
+	if s.endpoint != nil {
+		u.Scheme = s.endpoint.Scheme
+		u.Host = s.endpoint.Host
+
+		base := strings.TrimSuffix(s.endpoint.Path, "/")
+		path = base + "/" + s.bucket + path
+		rawPath = base + "/" + escapeS3(s.bucket) + rawPath
+	}
+
+	u.Path = path
+	u.RawPath = rawPath
+
+	return u
+}
+
+func (s *s3Sink) put(ctx context.Context, key string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", s.format.contentType)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signV4(req, body, s.accessKey, s.secretKey, s.region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload of %s failed: %s: %s", key, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+type s3Writer struct {
+	sink *s3Sink
+	ctx  context.Context
+	key  string
+	// objects are the keys of the objects uploaded so far
+	objects []string
+}
+
+func (w *s3Writer) Write(batch *Batch) (int64, error) {
+	if len(batch.Rows) == 0 {
+		return 0, nil
+	}
+
+	var body bytes.Buffer
+	if err := w.sink.format.encode(&body, batch, true); err != nil {
+		return 0, err
+	}
+
+	key := fmt.Sprintf("%s.%05d%s", w.key, len(w.objects)+1, w.sink.format.extension)
+
+	if err := w.sink.put(w.ctx, key, body.Bytes()); err != nil {
+		return 0, err
+	}
+
+	w.objects = append(w.objects, key)
+
+	return int64(body.Len()), nil
+}
+
+func (w *s3Writer) Commit() error {
+	return nil
+}
+
+// Abort keeps the objects, the rows in them may already be purged
+func (w *s3Writer) Abort() error {
+	return nil
+}
+
+// Location lists the objects uploaded so far, separated by spaces, or the key
+// they are named after before the first one is
+func (w *s3Writer) Location() string {
+	if len(w.objects) == 0 {
+		return fmt.Sprintf("s3://%s/%s", w.sink.bucket, w.key)
+	}
+
+	locations := make([]string, len(w.objects))
+	for i, key := range w.objects {
+		locations[i] = fmt.Sprintf("s3://%s/%s", w.sink.bucket, key)
+	}
+
+	return strings.Join(locations, " ")
+}
+
+// signV4 signs the request with AWS Signature Version 4 for S3. Every header
+// set on the request is signed along with the host.
+func signV4(req *http.Request, body []byte, accessKey string, secretKey string, region string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := slices.Sorted(slices.Values(query[key]))
+		for _, value := range values {
+			pairs = append(pairs, escapeS3(key)+"="+escapeS3(value))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// escapeS3 escapes everything but the unreserved characters of RFC 3986
+func escapeS3(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
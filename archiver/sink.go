@@ -0,0 +1,137 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink is where archived rows are kept: files, another database or object
+// storage. A run opens a Writer for every table it archives.
+type Sink interface {
+	Open(ctx context.Context, table SinkTable) (Writer, error)
+	// Close releases what the sink holds, such as connections, once the runs
+	// using it are done
+	Close() error
+}
+
+// Writer receives the batches of one table of a run. Rows are purged from the
+// table once Write returned, so Write must only return after the rows are
+// kept. Commit is called when the run succeeded and Abort when it failed;
+// rows written before Abort may already be purged and must not be dropped.
+type Writer interface {
+	// Write keeps the batch and returns how many bytes it wrote, 0 for
+	// sinks that don't write bytes
+	Write(batch *Batch) (int64, error)
+	Commit() error
+	Abort() error
+	// Location tells where the rows are kept: a file path, URL or table
+	Location() string
+}
+
+// deleteRecorder is implemented by writers recording how many of the rows
+// they were given the run purged, which is told before Commit or Abort
+type deleteRecorder interface {
+	recordDeleted(deleted int64)
+}
+
+// SinkTable is the table of a run a Writer is opened for
+type SinkTable struct {
+	Name       string
+	RunID      string
+	StartedAt  time.Time
+	CutoffDate time.Time
+	OutputDir  string
+	// TimestampCol is the column the rows are archived by, empty for a table
+	// archived by a reference
+	TimestampCol string
+	// Purge tells whether the run deletes the archived rows from the source
+	Purge bool
+}
+
+// BaseName is the name archives of the table are given, without extension
+func (t SinkTable) BaseName() string {
+	return fmt.Sprintf("archived_%s_till_%s_at_%s", t.Name, t.CutoffDate.Format(time.RFC3339), t.StartedAt.UTC().Format(time.RFC3339))
+}
+
+// SinkFactory creates a sink from its options. Option names are lower case.
+type SinkFactory func(options map[string]string) (Sink, error)
+
+var (
+	sinksMu sync.RWMutex
+	sinks   = map[string]SinkFactory{}
+)
+
+// DefaultSink is the sink used when none is configured
+const DefaultSink = "csv"
+
+func init() {
+	RegisterSink("csv", newFileSinkFactory("csv"))
+	RegisterSink("jsonl", newFileSinkFactory("jsonl"))
+	RegisterSink("database", newDatabaseSink)
+	RegisterSink("s3", newS3Sink)
+}
+
+// RegisterSink makes a sink available under name, usually from the init
+// function of the package implementing it. It panics when name is taken.
+func RegisterSink(name string, factory SinkFactory) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+
+	name = strings.ToLower(name)
+
+	if factory == nil {
+		panic("archiver: RegisterSink factory is nil")
+	}
+
+	if _, taken := sinks[name]; taken {
+		panic("archiver: RegisterSink called twice for sink " + name)
+	}
+
+	sinks[name] = factory
+}
+
+// NewSink creates the sink registered under name, DefaultSink when name is
+// empty. Option names are case-insensitive.
+func NewSink(name string, options map[string]string) (Sink, error) {
+	if name == "" {
+		name = DefaultSink
+	}
+
+	sinksMu.RLock()
+	factory, ok := sinks[strings.ToLower(name)]
+	sinksMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q, expected one of: %s", name, strings.Join(SinkNames(), ", "))
+	}
+
+	lowered := make(map[string]string, len(options))
+	for key, value := range options {
+		lowered[strings.ToLower(key)] = value
+	}
+
+	sink, err := factory(lowered)
+	if err != nil {
+		return nil, fmt.Errorf("%s sink: %v", name, err)
+	}
+
+	return sink, nil
+}
+
+// SinkNames returns the names of the registered sinks, sorted
+func SinkNames() []string {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
@@ -0,0 +1,314 @@
+package archiver
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	database "github.com/fn3x/archivator/internal/db"
+)
+
+// archiveName matches the names file sinks give archives, see BaseName
+var archiveName = regexp.MustCompile(`^archived_(.+)_till_(.+)_at_(.+?)(\.[a-z]+)$`)
+
+// ParseArchiveName returns the table, cutoff and start of the run of an
+// archive file named by a file sink. ok is false for other names.
+func ParseArchiveName(path string) (table SinkTable, ok bool) {
+	match := archiveName.FindStringSubmatch(filepath.Base(path))
+	if match == nil {
+		return SinkTable{}, false
+	}
+
+	cutoffDate, err := time.Parse(time.RFC3339, match[2])
+	if err != nil {
+		return SinkTable{}, false
+	}
+
+	startedAt, err := time.Parse(time.RFC3339, match[3])
+	if err != nil {
+		return SinkTable{}, false
+	}
+
+	return SinkTable{Name: match[1], CutoffDate: cutoffDate, StartedAt: startedAt, OutputDir: filepath.Dir(path)}, true
+}
+
+// Verification is how the rows of an archive compare to the source and the
+// destination database
+type Verification struct {
+	Path  string
+	Table string
+	Rows  int
+	// InSource are the ids still in the source. Purged tells whether the
+	// run purged them and Kept how many rows its purge kept on purpose,
+	// since they changed after they were archived. More rows than that in
+	// the source were missed by the purge.
+	InSource []uint64
+	Purged   bool
+	Kept     int64
+	// Lost are the ids in neither the source nor the destination, only
+	// set when the archive was compared with a destination
+	Lost []uint64
+	// Mismatched are the ids whose row in the destination differs from
+	// the archived one
+	Mismatched []uint64
+	// Destination tells whether the archive was compared with a destination
+	Destination bool
+	// Cataloged tells whether the archive is in the catalog of its
+	// directory, ChecksumChanged whether it changed since it was added
+	Cataloged       bool
+	ChecksumChanged bool
+}
+
+// MissedPurge tells whether rows of a purged archive are still in the source
+// that the purge should have deleted
+func (v *Verification) MissedPurge() bool {
+	return v.Purged && int64(len(v.InSource)) > v.Kept
+}
+
+// OK is true when the verification found nothing wrong
+func (v *Verification) OK() bool {
+	return !v.MissedPurge() && len(v.Lost) == 0 && len(v.Mismatched) == 0 && !v.ChecksumChanged
+}
+
+// VerifyArchive reads the CSV or JSONL archive of the table at path and looks
+// its rows up by id in the source and, when destination isn't nil, in
+// destTable of the destination, comparing the archived values with the ones
+// there. An archive in the catalog of its directory is checked against its
+// checksum there too, and the catalog tells whether its run purged the rows.
+// purged tells that for archives missing from the catalog.
+func VerifyArchive(path string, table string, source *sql.DB, destination *sql.DB, destTable string, purged bool) (*Verification, error) {
+	columns, hashes, err := hashArchive(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Verification{Path: path, Table: table, Rows: len(hashes), Destination: destination != nil, Purged: purged}
+
+	catalog, err := LoadCatalog(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry, ok := catalog.Entry(filepath.Base(path)); ok {
+		checksum, err := FileChecksum(path)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Cataloged = true
+		result.ChecksumChanged = checksum != entry.Checksum
+		result.Purged = entry.Purged
+		result.Kept = max(entry.Rows-entry.Deleted, 0)
+	}
+
+	ids := slices.Sorted(maps.Keys(hashes))
+
+	inSource, err := database.RowsByID(source, table, []string{"id"}, ids)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't look the rows up in %s: %v", table, err)
+	}
+
+	var inDestination map[uint64][]any
+	if destination != nil {
+		destColumns, err := database.TableColumns(destination, destTable)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(destColumns) == 0 {
+			return nil, fmt.Errorf("table %s not found in the destination", destTable)
+		}
+
+		for _, column := range columns {
+			if !slices.Contains(destColumns, column) {
+				return nil, fmt.Errorf("column %s of the archive not found in table %s of the destination", column, destTable)
+			}
+		}
+
+		if inDestination, err = database.RowsByID(destination, destTable, columns, ids); err != nil {
+			return nil, fmt.Errorf("couldn't look the rows up in %s of the destination: %v", destTable, err)
+		}
+	}
+
+	jsonl := filepath.Ext(path) == ".jsonl"
+
+	for _, id := range ids {
+		if _, ok := inSource[id]; ok {
+			result.InSource = append(result.InSource, id)
+		}
+
+		if destination == nil {
+			continue
+		}
+
+		values, ok := inDestination[id]
+		if !ok {
+			if _, ok := inSource[id]; !ok {
+				result.Lost = append(result.Lost, id)
+			}
+
+			continue
+		}
+
+		if rowHash(values, jsonl) != hashes[id] {
+			result.Mismatched = append(result.Mismatched, id)
+		}
+	}
+
+	return result, nil
+}
+
+// hashArchive reads the archive at path and returns its columns and the hash
+// of every row by id
+func hashArchive(path string) ([]string, map[uint64][sha256.Size]byte, error) {
+	var columns []string
+	hashes := map[uint64][sha256.Size]byte{}
+	jsonl := filepath.Ext(path) == ".jsonl"
+
+	err := readArchive(path, func(rowColumns []string, row []any) error {
+		if columns == nil {
+			columns = rowColumns
+		}
+
+		i := slices.Index(rowColumns, "id")
+		if i < 0 {
+			return fmt.Errorf("%s has no id column", path)
+		}
+
+		id, err := archiveID(row[i])
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		hashes[id] = rowHash(row, jsonl)
+
+		return nil
+	})
+
+	return columns, hashes, err
+}
+
+// readArchive calls row with every row of the CSV or JSONL archive at path
+func readArchive(path string, row func(columns []string, row []any) error) error {
+	var read func(io.Reader, func(columns []string, row []any) error) error
+
+	switch filepath.Ext(path) {
+	case ".csv":
+		read = readCSV
+	case ".jsonl":
+		read = readJSONL
+	default:
+		return fmt.Errorf("%s isn't a csv or jsonl archive", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return read(bufio.NewReader(file), row)
+}
+
+// archiveID reads the id of a row read from an archive
+func archiveID(value any) (uint64, error) {
+	id, err := strconv.ParseUint(verifyValue(value, false), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %v", value)
+	}
+
+	return id, nil
+}
+
+func readCSV(r io.Reader, row func(columns []string, row []any) error) error {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		values := make([]any, len(record))
+		for i, value := range record {
+			values[i] = value
+		}
+
+		if err := row(header, values); err != nil {
+			return err
+		}
+	}
+}
+
+// readJSONL reads the objects of the archive with their keys sorted, since
+// JSON objects keep no order
+func readJSONL(r io.Reader, row func(columns []string, row []any) error) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	for {
+		var object map[string]any
+		if err := decoder.Decode(&object); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		columns := slices.Sorted(maps.Keys(object))
+
+		values := make([]any, len(columns))
+		for i, column := range columns {
+			values[i] = object[column]
+		}
+
+		if err := row(columns, values); err != nil {
+			return err
+		}
+	}
+}
+
+// rowHash hashes the values as the archive format writes them
+func rowHash(values []any, jsonl bool) [sha256.Size]byte {
+	rendered := make([]string, len(values))
+	for i, value := range values {
+		rendered[i] = verifyValue(value, jsonl)
+	}
+
+	return sha256.Sum256([]byte(strings.Join(rendered, "\x1f")))
+}
+
+// verifyValue renders a value read from an archive or a database as the
+// archive format writes it. CSV writes NULL as an empty string, so the two
+// compare equal.
+func verifyValue(value any, jsonl bool) string {
+	if jsonl {
+		value = jsonValue(value)
+	}
+
+	return formatValue(value)
+}
@@ -5,26 +5,110 @@ package cmd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 
+	"github.com/fn3x/archivator/archiver"
+	database "github.com/fn3x/archivator/internal/db"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/term"
 )
 
+type configKey struct {
+	key    string
+	flag   string
+	usage  string
+	secret bool
+	number bool
+}
+
+// configKeys lists every setting that can be given as a flag to config and
+// read or changed with config get/set.
+var configKeys = []configKey{
+	{key: "outputDir", flag: "output-dir", usage: "directory to write archive files to"},
+	{key: "socket", flag: "socket", usage: "MySQL socket used by connections without a socket of their own"},
+	{key: "defaultsFile", usage: "MySQL option file to read credentials from"},
+	{key: "history", flag: "history", usage: "record runs in archi_runs of the source or destination database: off, source or destination"},
+	{key: "sink.type", flag: "sink", usage: "where to write the archive: csv, jsonl, database (the destination connection) or s3"},
+	{key: "sink.prefix", flag: "sink-prefix", usage: "prefix of the archived tables (database) or objects (s3)"},
+	{key: "sink.format", flag: "sink-format", usage: "format of the s3 objects: csv or jsonl"},
+	{key: "sink.bucket", flag: "sink-bucket", usage: "s3 bucket"},
+	{key: "sink.region", flag: "sink-region", usage: "s3 region (default: AWS_REGION or us-east-1)"},
+	{key: "sink.endpoint", flag: "sink-endpoint", usage: "URL of an S3 compatible storage, e.g. https://minio:9000"},
+	{key: "sink.accessKey", flag: "sink-access-key", usage: "s3 access key id (default: AWS_ACCESS_KEY_ID)"},
+	{key: "sink.secretKey", flag: "sink-secret-key", usage: "s3 secret access key (default: AWS_SECRET_ACCESS_KEY)", secret: true},
+	{key: "source.engine", flag: "source-engine", usage: "source database engine: mysql, postgres or sqlite"},
+	{key: "source.protocol", flag: "source-protocol", usage: "source transport: tcp or unix"},
+	{key: "source.socket", flag: "source-socket", usage: "source MySQL socket location"},
+	{key: "source.host", flag: "source-host", usage: "source host"},
+	{key: "source.port", flag: "source-port", usage: "source port", number: true},
+	{key: "source.user", flag: "source-user", usage: "source user"},
+	{key: "source.password", flag: "source-password", usage: "source password", secret: true},
+	{key: "source.db", flag: "source-db", usage: "source database name, the database file for sqlite"},
+	{key: "source.defaultsGroup", flag: "source-defaults-group", usage: "option file group with source credentials"},
+	{key: "source.tls.mode", flag: "source-tls-mode", usage: "source TLS mode: disabled, preferred, required, verify-ca or verify-identity"},
+	{key: "source.tls.ca", flag: "source-tls-ca", usage: "source CA certificate file"},
+	{key: "source.tls.cert", flag: "source-tls-cert", usage: "source client certificate file"},
+	{key: "source.tls.key", flag: "source-tls-key", usage: "source client key file"},
+	{key: "source.tls.serverName", flag: "source-tls-server-name", usage: "source server name to verify the certificate against"},
+	{key: "destination.engine", flag: "dest-engine", usage: "destination database engine: mysql, postgres or sqlite"},
+	{key: "destination.protocol", flag: "dest-protocol", usage: "destination transport: tcp or unix"},
+	{key: "destination.socket", flag: "dest-socket", usage: "destination MySQL socket location"},
+	{key: "destination.host", flag: "dest-host", usage: "destination host"},
+	{key: "destination.port", flag: "dest-port", usage: "destination port", number: true},
+	{key: "destination.user", flag: "dest-user", usage: "destination user"},
+	{key: "destination.password", flag: "dest-password", usage: "destination password", secret: true},
+	{key: "destination.db", flag: "dest-db", usage: "destination database name, the database file for sqlite"},
+	{key: "destination.defaultsGroup", flag: "dest-defaults-group", usage: "option file group with destination credentials"},
+	{key: "destination.tls.mode", flag: "dest-tls-mode", usage: "destination TLS mode: disabled, preferred, required, verify-ca or verify-identity"},
+	{key: "destination.tls.ca", flag: "dest-tls-ca", usage: "destination CA certificate file"},
+	{key: "destination.tls.cert", flag: "dest-tls-cert", usage: "destination client certificate file"},
+	{key: "destination.tls.key", flag: "dest-tls-key", usage: "destination client key file"},
+	{key: "destination.tls.serverName", flag: "dest-tls-server-name", usage: "destination server name to verify the certificate against"},
+}
+
 var cfgCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Create config file",
 	Long: `
-Create config file archi.json in the current directory with database connections, ports and users`,
+Create config file archi.json in the current directory with database connections, ports and users.
+
+Every value can be given as a flag. When stdin is not a terminal or --non-interactive is set
+no questions are asked: the flags are merged into the existing config file and saved.`,
 	Args: cobra.MaximumNArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		nonInteractive, err := cmd.Flags().GetBool("non-interactive")
+		if err != nil {
+			return err
+		}
+
+		readErr := viper.ReadInConfig()
+		if readErr != nil && !errors.As(readErr, &viper.ConfigFileNotFoundError{}) {
+			return readErr
+		}
+
+		if nonInteractive || !term.IsTerminal(int(os.Stdin.Fd())) {
+			if err := applyConfigFlags(cmd); err != nil {
+				return err
+			}
+
+			if err := writeConfig(); err != nil {
+				return err
+			}
+
+			fmt.Println("Configuration has been successfully saved.")
+			return nil
+		}
+
 		scanner := bufio.NewScanner(os.Stdin)
-		if err := viper.ReadInConfig(); err == nil {
+		if readErr == nil {
 			fmt.Printf("Found config file: %s\n\nDo you want to continue and override existing config file? (y/n) ", viper.ConfigFileUsed())
 
 			scanner.Scan()
@@ -41,23 +125,38 @@ Create config file archi.json in the current directory with database connections
 
 		isFile := true
 
-		for {
-			fmt.Print("Archive to a (f)ile or to a (d)atabase: ")
-
-			scanner.Scan()
-			answer := scanner.Text()
-			if scanner.Err() != nil {
-				return scanner.Err()
-			}
-
-			if answer == "f" || answer == "F" {
-				break
+		switch {
+		case flagsChanged(cmd, "dest-"):
+			isFile = false
+		case cmd.Flags().Changed("output-dir"):
+		default:
+			for {
+				fmt.Print("Archive to a (f)ile or to a (d)atabase: ")
+
+				scanner.Scan()
+				answer := scanner.Text()
+				if scanner.Err() != nil {
+					return scanner.Err()
+				}
+
+				if answer == "f" || answer == "F" {
+					break
+				}
+
+				if answer == "d" || answer == "D" {
+					isFile = false
+					break
+				}
 			}
+		}
 
-			if answer == "d" || answer == "D" {
-				isFile = false
-				break
-			}
+		switch {
+		case cmd.Flags().Changed("sink"):
+			viper.Set("sink.type", cmd.Flags().Lookup("sink").Value.String())
+		case !isFile:
+			viper.Set("sink.type", "database")
+		case strings.EqualFold(viper.GetString("sink.type"), "database"):
+			viper.Set("sink.type", archiver.DefaultSink)
 		}
 
 		if isFile {
@@ -68,12 +167,9 @@ Create config file archi.json in the current directory with database connections
 				pwd = "./"
 			}
 
-			fmt.Printf("Output directory (%s): ", pwd)
-
-			scanner.Scan()
-			outputDir := scanner.Text()
-			if scanner.Err() != nil {
-				return scanner.Err()
+			outputDir, err := promptValue(cmd, scanner, "output-dir", fmt.Sprintf("Output directory (%s): ", pwd))
+			if err != nil {
+				return err
 			}
 
 			if outputDir != "" {
@@ -81,195 +177,467 @@ Create config file archi.json in the current directory with database connections
 			}
 		}
 
-		switch runtime.GOOS {
-		case "linux":
-			fmt.Print("MySQL socket location (/var/run/mysqld/mysqld.sock): ")
-			scanner.Scan()
-			socket := scanner.Text()
-			if scanner.Err() != nil {
-				return scanner.Err()
-			}
+		fmt.Print("\n--- Source connection ---\n\n")
+		if err := promptConnection(cmd, scanner, "source", "source-"); err != nil {
+			return err
+		}
 
-			if socket != "" {
-				viper.Set("socket", "/var/run/mysqld/mysqld.sock")
-			}
-		case "darwin":
-			fmt.Print("MySQL socket location (/tmp/mysql.sock): ")
-			scanner.Scan()
-			socket := scanner.Text()
-			if scanner.Err() != nil {
-				return scanner.Err()
+		if !isFile {
+			fmt.Print("\n\n--- Destination connection ---\n\n")
+			if err := promptConnection(cmd, scanner, "destination", "dest-"); err != nil {
+				return err
 			}
+		}
 
-			if socket != "" {
-				viper.Set("socket", "/tmp/mysql.sock")
-			}
+		if err := writeConfig(); err != nil {
+			return err
 		}
 
-		fmt.Print("\n--- Source connection ---\n\n")
-		fmt.Print("host (127.0.0.1): ")
-		scanner.Scan()
-		sourceHost := scanner.Text()
-		if scanner.Err() != nil {
-			return scanner.Err()
+		fmt.Printf("\nConfiguration has been successfully saved.\n")
+
+		return nil
+	},
+}
+
+var cfgSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a single value in the config file",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := lookupConfigKey(args[0])
+		if err != nil {
+			return err
 		}
 
-		fmt.Print("port (3306): ")
-		scanner.Scan()
-		mainPortRead := scanner.Text()
-		if scanner.Err() != nil {
-			return scanner.Err()
+		readErr := viper.ReadInConfig()
+		if readErr != nil && !errors.As(readErr, &viper.ConfigFileNotFoundError{}) {
+			return readErr
 		}
 
-		if mainPortRead == "" {
-			mainPortRead = viper.GetString("source.port")
+		if err := setConfigValue(key, args[1]); err != nil {
+			return err
 		}
 
-		sourcePort, err := strconv.Atoi(mainPortRead)
+		return writeConfig()
+	},
+}
+
+var cfgGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print a single value from the config file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := lookupConfigKey(args[0])
 		if err != nil {
-			return fmt.Errorf("wrong value provided for port")
+			return err
 		}
 
-		fmt.Print("user: ")
-		scanner.Scan()
-		sourceUser := scanner.Text()
-		if scanner.Err() != nil {
-			return scanner.Err()
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("%+v\n\n%s", err, "To create config file:\n  archi config")
 		}
 
-		fmt.Print("password: ")
-		byteMainPassword, err := term.ReadPassword(int(syscall.Stdin))
+		unmask, err := cmd.Flags().GetBool("unmask")
 		if err != nil {
-			return scanner.Err()
+			return err
 		}
-		sourcePassword := string(byteMainPassword)
 
-		fmt.Print("\ndatabase name: ")
-		scanner.Scan()
-		sourceDB := scanner.Text()
-		if scanner.Err() != nil {
-			return scanner.Err()
+		fmt.Println(displayValue(key, unmask))
+
+		return nil
+	},
+}
+
+var cfgShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the config file with passwords masked",
+	Args:  cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("%+v\n\n%s", err, "To create config file:\n  archi config")
 		}
 
-		defer func() {
-			if err != nil {
-				fmt.Printf("%+v", err)
-				return
-			}
+		unmask, err := cmd.Flags().GetBool("unmask")
+		if err != nil {
+			return err
+		}
 
-			err = viper.WriteConfigAs("archi.json")
-			if err != nil {
-				fmt.Printf("couldn't write config to file: %+v", err)
-				return
-			}
+		fmt.Printf("Config file: %s\n\n", viper.ConfigFileUsed())
 
-			fmt.Printf("\nConfiguration has been successfully saved.\n")
-		}()
+		for _, key := range configKeys {
+			fmt.Printf("%-26s %s\n", key.key, displayValue(key, unmask))
+		}
 
-		if isFile {
-			if sourceHost != "" {
-				viper.Set("source.host", sourceHost)
-			}
+		return nil
+	},
+}
 
-			if sourcePort != 0 {
-				viper.Set("source.port", sourcePort)
-			}
+func init() {
+	initConfig()
 
-			viper.Set("source.db", sourceDB)
-			viper.Set("source.user", sourceUser)
-			viper.Set("source.password", sourcePassword)
+	for _, key := range configKeys {
+		if key.flag == "" {
+			continue
+		}
 
-			return nil
+		if key.number {
+			cfgCmd.Flags().Int(key.flag, 0, key.usage)
+		} else {
+			cfgCmd.Flags().String(key.flag, "", key.usage)
 		}
+	}
 
-		fmt.Print("\n\n--- Destination connection ---\n\n")
-		fmt.Print("host (127.0.0.1): ")
-		scanner.Scan()
-		destHost := scanner.Text()
-		if scanner.Err() != nil {
-			return scanner.Err()
+	cfgCmd.Flags().Bool("non-interactive", false, "never prompt, only save the values given as flags")
+	cfgGetCmd.Flags().Bool("unmask", false, "print passwords in clear text")
+	cfgShowCmd.Flags().Bool("unmask", false, "print passwords in clear text")
+
+	cfgCmd.AddCommand(cfgSetCmd, cfgGetCmd, cfgShowCmd)
+	rootCmd.AddCommand(cfgCmd)
+}
+
+func initConfig() {
+	viper.SetDefault("socket", "")
+	viper.SetDefault("source.host", "127.0.0.1")
+	viper.SetDefault("source.port", "3306")
+	viper.SetDefault("source.db", "")
+	viper.SetDefault("source.user", "")
+	viper.SetDefault("source.password", "")
+	viper.SetDefault("destination.host", "127.0.0.1")
+	viper.SetDefault("destination.port", "3306")
+
+	viper.AddConfigPath(".")
+	viper.SetConfigType("json")
+	viper.SetConfigName("archi")
+}
+
+// writeConfig saves the settings to the config file that was read, or to
+// archi.json in the current directory when there was none.
+func writeConfig() error {
+	var err error
+	if viper.ConfigFileUsed() != "" {
+		err = viper.WriteConfig()
+	} else {
+		err = viper.WriteConfigAs("archi.json")
+	}
+
+	if err != nil {
+		return fmt.Errorf("couldn't write config to file: %+v", err)
+	}
+
+	return nil
+}
+
+// applyConfigFlags copies every config flag that was given on the command line into viper.
+func applyConfigFlags(cmd *cobra.Command) error {
+	for _, key := range configKeys {
+		if key.flag == "" || !cmd.Flags().Changed(key.flag) {
+			continue
 		}
 
-		fmt.Print("port (3306): ")
-		scanner.Scan()
-		archivePortRead := scanner.Text()
-		if scanner.Err() != nil {
-			return scanner.Err()
+		if err := setConfigValue(key, cmd.Flags().Lookup(key.flag).Value.String()); err != nil {
+			return err
 		}
+	}
 
-		if archivePortRead == "" {
-			archivePortRead = viper.GetString("destination.port")
+	for name, flagPrefix := range map[string]string{"source": "source-", "destination": "dest-"} {
+		if !cmd.Flags().Changed(flagPrefix + "tls-server-name") {
+			continue
 		}
 
-		destPort, err := strconv.Atoi(archivePortRead)
+		dialect, err := database.LookupDialect(viper.GetString(name + ".engine"))
+		if err == nil && dialect.Name() == database.Postgres {
+			return fmt.Errorf("--%stls-server-name is not supported for PostgreSQL, the certificate is verified against the host", flagPrefix)
+		}
+	}
+
+	return nil
+}
+
+func flagsChanged(cmd *cobra.Command, prefix string) bool {
+	for _, key := range configKeys {
+		if key.flag != "" && strings.HasPrefix(key.flag, prefix) && cmd.Flags().Changed(key.flag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func lookupConfigKey(name string) (configKey, error) {
+	for _, key := range configKeys {
+		if strings.EqualFold(key.key, name) {
+			return key, nil
+		}
+	}
+
+	known := make([]string, 0, len(configKeys))
+	for _, key := range configKeys {
+		known = append(known, key.key)
+	}
+	slices.Sort(known)
+
+	return configKey{}, fmt.Errorf("unknown config key %q, expected one of: %s", name, strings.Join(known, ", "))
+}
+
+func setConfigValue(key configKey, value string) error {
+	if !key.number {
+		viper.Set(key.key, value)
+		return nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("wrong value provided for %s", key.key)
+	}
+
+	viper.Set(key.key, number)
+
+	return nil
+}
+
+func displayValue(key configKey, unmask bool) string {
+	value := viper.GetString(key.key)
+	if key.secret && !unmask && value != "" {
+		return "********"
+	}
+
+	return value
+}
+
+// promptValue returns the value of the flag when it was given, otherwise it
+// prints the prompt and reads a line from the scanner.
+func promptValue(cmd *cobra.Command, scanner *bufio.Scanner, flag string, prompt string) (string, error) {
+	if cmd.Flags().Changed(flag) {
+		return cmd.Flags().Lookup(flag).Value.String(), nil
+	}
+
+	fmt.Print(prompt)
+	scanner.Scan()
+	if scanner.Err() != nil {
+		return "", scanner.Err()
+	}
+
+	return scanner.Text(), nil
+}
+
+func promptPassword(cmd *cobra.Command, flag string) (string, error) {
+	if cmd.Flags().Changed(flag) {
+		return cmd.Flags().Lookup(flag).Value.String(), nil
+	}
+
+	fmt.Print("password: ")
+	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return "", fmt.Errorf("couldn't read password from stdin: %+v", err)
+	}
+	fmt.Println()
+
+	return string(bytePassword), nil
+}
+
+// promptConnection asks for the settings of the named connection, skipping
+// the questions answered by flags starting with flagPrefix.
+func promptConnection(cmd *cobra.Command, scanner *bufio.Scanner, name string, flagPrefix string) error {
+	var dialect database.Dialect
+	for dialect == nil {
+		answer, err := promptValue(cmd, scanner, flagPrefix+"engine", fmt.Sprintf("engine (%s) [mysql]: ", strings.Join(database.Engines, "/")))
 		if err != nil {
-			return fmt.Errorf("wrong value provided for port")
+			return err
 		}
 
-		fmt.Print("user: ")
-		scanner.Scan()
-		destUser := scanner.Text()
-		if scanner.Err() != nil {
-			return scanner.Err()
+		dialect, err = database.LookupDialect(answer)
+		if err != nil && cmd.Flags().Changed(flagPrefix+"engine") {
+			return err
 		}
+	}
 
-		fmt.Print("password: ")
-		byteArchivePassword, err := term.ReadPassword(int(syscall.Stdin))
+	if dialect.Name() == database.SQLite {
+		file, err := promptValue(cmd, scanner, flagPrefix+"db", "database file: ")
 		if err != nil {
-			return fmt.Errorf("couldn't read password from stdin: %+v", err)
+			return err
 		}
 
-		destPassword := string(byteArchivePassword)
+		viper.Set(name+".engine", dialect.Name())
+		viper.Set(name+".db", file)
+
+		return nil
+	}
+
+	postgres := dialect.Name() == database.Postgres
+
+	protocol := ""
+	if cmd.Flags().Changed(flagPrefix + "protocol") {
+		protocol = cmd.Flags().Lookup(flagPrefix + "protocol").Value.String()
+	} else if cmd.Flags().Changed(flagPrefix + "socket") {
+		protocol = "unix"
+	}
+
+	for protocol != "tcp" && protocol != "unix" {
+		fmt.Print("Connect over (t)cp or (u)nix socket: ")
 
-		fmt.Print("\ndatabase name: ")
 		scanner.Scan()
-		destDB := scanner.Text()
+		answer := scanner.Text()
 		if scanner.Err() != nil {
 			return scanner.Err()
 		}
 
-		if sourceHost != "" {
-			viper.Set("source.host", sourceHost)
+		switch answer {
+		case "t", "T":
+			protocol = "tcp"
+		case "u", "U":
+			protocol = "unix"
+		}
+	}
+
+	var (
+		host   string
+		port   int
+		socket string
+		err    error
+	)
+
+	if protocol == "unix" {
+		defaultSocket := "/var/run/mysqld/mysqld.sock"
+		if postgres {
+			defaultSocket = "/var/run/postgresql"
+		} else if runtime.GOOS == "darwin" {
+			defaultSocket = "/tmp/mysql.sock"
 		}
 
-		if sourcePort != 0 {
-			viper.Set("source.port", sourcePort)
+		socket, err = promptValue(cmd, scanner, flagPrefix+"socket", fmt.Sprintf("socket (%s): ", defaultSocket))
+		if err != nil {
+			return err
 		}
 
-		viper.Set("source.db", sourceDB)
-		viper.Set("source.user", sourceUser)
-		viper.Set("source.password", sourcePassword)
+		if socket == "" {
+			socket = defaultSocket
+		}
+	} else {
+		host, err = promptValue(cmd, scanner, flagPrefix+"host", "host (127.0.0.1): ")
+		if err != nil {
+			return err
+		}
 
-		if destHost != "" {
-			viper.Set("destination.host", destHost)
+		defaultPort := "3306"
+		if postgres {
+			defaultPort = "5432"
 		}
 
-		if destPort > 0 {
-			viper.Set("destination.port", destPort)
+		portRead, err := promptValue(cmd, scanner, flagPrefix+"port", fmt.Sprintf("port (%s): ", defaultPort))
+		if err != nil {
+			return err
 		}
 
-		viper.Set("destination.db", destDB)
-		viper.Set("destination.user", destUser)
-		viper.Set("destination.password", destPassword)
+		if portRead == "" && viper.InConfig(name+".port") {
+			portRead = viper.GetString(name + ".port")
+		}
 
-		return nil
-	},
-}
+		if portRead == "" {
+			portRead = defaultPort
+		}
 
-func init() {
-	initConfig()
-	rootCmd.AddCommand(cfgCmd)
+		port, err = strconv.Atoi(portRead)
+		if err != nil {
+			return fmt.Errorf("wrong value provided for port")
+		}
+	}
+
+	user, err := promptValue(cmd, scanner, flagPrefix+"user", "user: ")
+	if err != nil {
+		return err
+	}
+
+	password, err := promptPassword(cmd, flagPrefix+"password")
+	if err != nil {
+		return err
+	}
+
+	db, err := promptValue(cmd, scanner, flagPrefix+"db", "database name: ")
+	if err != nil {
+		return err
+	}
+
+	viper.Set(name+".engine", dialect.Name())
+	viper.Set(name+".protocol", protocol)
+	viper.Set(name+".socket", socket)
+
+	if host != "" {
+		viper.Set(name+".host", host)
+	}
+
+	if port > 0 {
+		viper.Set(name+".port", port)
+	}
+
+	viper.Set(name+".db", db)
+	viper.Set(name+".user", user)
+	viper.Set(name+".password", password)
+
+	if cmd.Flags().Changed(flagPrefix + "defaults-group") {
+		viper.Set(name+".defaultsGroup", cmd.Flags().Lookup(flagPrefix+"defaults-group").Value.String())
+	}
+
+	return promptTLS(cmd, scanner, name, flagPrefix, postgres)
 }
 
-func initConfig() {
-	viper.SetDefault("socket", "")
-	viper.SetDefault("source.host", "127.0.0.1")
-	viper.SetDefault("source.port", "3306")
-	viper.SetDefault("source.db", "")
-	viper.SetDefault("source.user", "")
-	viper.SetDefault("source.password", "")
+// promptTLS asks for the TLS settings of the named connection. Files are only
+// asked for when the mode uses TLS. PostgreSQL verifies the certificate
+// against the host, so the server name isn't asked for.
+func promptTLS(cmd *cobra.Command, scanner *bufio.Scanner, name string, flagPrefix string, postgres bool) error {
+	if postgres && cmd.Flags().Changed(flagPrefix+"tls-server-name") {
+		return fmt.Errorf("--%stls-server-name is not supported for PostgreSQL, the certificate is verified against the host", flagPrefix)
+	}
+
+	mode := ""
+	for {
+		answer, err := promptValue(cmd, scanner, flagPrefix+"tls-mode", fmt.Sprintf("TLS mode (%s) [disabled]: ", strings.Join(database.TLSModes, "/")))
+		if err != nil {
+			return err
+		}
 
-	viper.AddConfigPath(".")
-	viper.SetConfigType("json")
-	viper.SetConfigName("archi")
+		if answer == "" {
+			answer = database.TLSDisabled
+		}
+
+		if slices.Contains(database.TLSModes, answer) {
+			mode = answer
+			break
+		}
+
+		if cmd.Flags().Changed(flagPrefix + "tls-mode") {
+			return fmt.Errorf("wrong value provided for TLS mode")
+		}
+	}
+
+	viper.Set(name+".tls.mode", mode)
+
+	if mode == database.TLSDisabled {
+		return nil
+	}
+
+	prompts := []struct {
+		key    string
+		flag   string
+		prompt string
+	}{
+		{key: "ca", flag: "tls-ca", prompt: "CA file: "},
+		{key: "cert", flag: "tls-cert", prompt: "client certificate file: "},
+		{key: "key", flag: "tls-key", prompt: "client key file: "},
+		{key: "serverName", flag: "tls-server-name", prompt: "server name (host): "},
+	}
+
+	for _, p := range prompts {
+		if postgres && p.key == "serverName" {
+			viper.Set(name+".tls.serverName", "")
+			continue
+		}
+
+		value, err := promptValue(cmd, scanner, flagPrefix+p.flag, p.prompt)
+		if err != nil {
+			return err
+		}
+
+		viper.Set(name+".tls."+p.key, value)
+	}
+
+	return nil
 }
@@ -0,0 +1,260 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	database "github.com/fn3x/archivator/internal/db"
+	"github.com/fn3x/archivator/internal/helpers"
+	"github.com/fn3x/archivator/internal/mycnf"
+	"github.com/go-sql-driver/mysql"
+	"github.com/spf13/viper"
+)
+
+// connectionConfig builds the driver config for the "source" or "destination"
+// connection. Values from archi.json win, then the MySQL option files
+// ([client], [archi] and the connection's defaultsGroup), then the defaults.
+//
+// The connection goes over the unix socket when <name>.socket is set, or when
+// its host isn't set and there is a global "socket" or one in the option
+// files, unless its protocol is set to tcp. TLS settings come from <name>.tls
+// or the ssl-* options of the option files.
+func connectionConfig(name string) (*mysql.Config, error) {
+	groups := []string{"client", "archi"}
+	if group := viper.GetString(name + ".defaultsGroup"); group != "" {
+		groups = append(groups, group)
+	}
+
+	opts, err := mycnf.Load(viper.GetString("defaultsFile"), groups)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read MySQL option files: %+v", err)
+	}
+
+	dbConfig := mysql.NewConfig()
+
+	dbConfig.DBName = optionValue(opts, name+".db", "database")
+	dbConfig.User = optionValue(opts, name+".user", "user")
+	dbConfig.Passwd = optionValue(opts, name+".password", "password")
+
+	// the global socket and the one of the option files must not take over a
+	// connection whose host is set explicitly in archi.json, they only fill
+	// in the socket when its protocol is unix
+	socket := viper.GetString(name + ".socket")
+
+	fallbackSocket := viper.GetString("socket")
+	if value, ok := opts.Get("socket"); ok && fallbackSocket == "" {
+		fallbackSocket = value
+	}
+
+	if socket == "" && !viper.InConfig(name+".host") {
+		socket = fallbackSocket
+	}
+
+	protocol := strings.ToLower(optionValue(opts, name+".protocol", "protocol"))
+	switch protocol {
+	case "":
+		if socket != "" {
+			protocol = "unix"
+		} else {
+			protocol = "tcp"
+		}
+	case "socket":
+		protocol = "unix"
+	}
+
+	switch protocol {
+	case "tcp":
+		dbConfig.Net = "tcp"
+		dbConfig.Addr = fmt.Sprintf("%s:%s", optionValue(opts, name+".host", "host"), optionValue(opts, name+".port", "port"))
+	case "unix":
+		if socket == "" {
+			socket = fallbackSocket
+		}
+
+		if err := helpers.AssertError(socket != "", fmt.Sprintf("Expected %s.socket to be set for unix protocol", name)); err != nil {
+			return nil, err
+		}
+
+		dbConfig.Net = "unix"
+		dbConfig.Addr = socket
+	default:
+		return nil, fmt.Errorf("unknown protocol %q for %s connection, expected tcp or unix", protocol, name)
+	}
+
+	tlsOptions := database.TLSOptions{
+		Mode:       strings.ReplaceAll(strings.ToLower(optionValue(opts, name+".tls.mode", "ssl_mode")), "_", "-"),
+		CAFile:     optionValue(opts, name+".tls.ca", "ssl_ca"),
+		CertFile:   optionValue(opts, name+".tls.cert", "ssl_cert"),
+		KeyFile:    optionValue(opts, name+".tls.key", "ssl_key"),
+		ServerName: viper.GetString(name + ".tls.serverName"),
+	}
+
+	if err := database.ConfigureTLS(dbConfig, "archi-"+name, tlsOptions); err != nil {
+		return nil, fmt.Errorf("%s connection: %v", name, err)
+	}
+
+	return dbConfig, nil
+}
+
+// connect opens and pings the named connection
+func connect(name string) (*sql.DB, error) {
+	engine, dsn, err := connectionDSN(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return database.Connect(engine, dsn, ctx)
+}
+
+// connectionDSN returns the engine of the named connection, set with
+// <name>.engine, and the DSN to connect to it with
+func connectionDSN(name string) (engine string, dsn string, err error) {
+	dialect, err := database.LookupDialect(viper.GetString(name + ".engine"))
+	if err != nil {
+		return "", "", fmt.Errorf("%s connection: %v", name, err)
+	}
+
+	switch dialect.Name() {
+	case database.Postgres:
+		dsn, err := postgresDSN(name)
+		return database.Postgres, dsn, err
+	case database.SQLite:
+		dsn, err := sqliteDSN(name)
+		return database.SQLite, dsn, err
+	}
+
+	dbConfig, err := connectionConfig(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	return database.MySQL, dbConfig.FormatDSN(), nil
+}
+
+// postgresSSLModes maps the TLS modes to the sslmode of PostgreSQL
+var postgresSSLModes = map[string]string{
+	database.TLSDisabled:       "disable",
+	database.TLSPreferred:      "prefer",
+	database.TLSRequired:       "require",
+	database.TLSVerifyCA:       "verify-ca",
+	database.TLSVerifyIdentity: "verify-full",
+}
+
+// postgresDSN builds the connection string of a PostgreSQL connection. The
+// MySQL option files don't apply; what archi.json leaves out is taken by
+// the driver from the PG* environment variables and ~/.pgpass.
+func postgresDSN(name string) (string, error) {
+	var b strings.Builder
+
+	add := func(key string, value string) {
+		if value == "" {
+			return
+		}
+
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		value = strings.ReplaceAll(value, "'", `\'`)
+		fmt.Fprintf(&b, "%s='%s' ", key, value)
+	}
+
+	// like for MySQL, the global socket doesn't take over a connection
+	// whose host is set, it only fills in the socket when its protocol is unix
+	socket := viper.GetString(name + ".socket")
+	fallbackSocket := viper.GetString("socket")
+
+	if socket == "" && !viper.InConfig(name+".host") {
+		socket = fallbackSocket
+	}
+
+	protocol := strings.ToLower(viper.GetString(name + ".protocol"))
+	if protocol == "" && socket != "" || protocol == "unix" || protocol == "socket" {
+		if socket == "" {
+			socket = fallbackSocket
+		}
+
+		if err := helpers.AssertError(socket != "", fmt.Sprintf("Expected %s.socket to be set for unix protocol", name)); err != nil {
+			return "", err
+		}
+
+		// the host of a unix socket is the directory it is in
+		if strings.HasPrefix(filepath.Base(socket), ".s.PGSQL.") {
+			socket = filepath.Dir(socket)
+		}
+
+		add("host", socket)
+	} else if protocol == "" || protocol == "tcp" {
+		add("host", viper.GetString(name+".host"))
+
+		// the port default is MySQL's
+		if viper.InConfig(name + ".port") {
+			add("port", viper.GetString(name+".port"))
+		}
+	} else {
+		return "", fmt.Errorf("unknown protocol %q for %s connection, expected tcp or unix", protocol, name)
+	}
+
+	add("user", viper.GetString(name+".user"))
+	add("password", viper.GetString(name+".password"))
+	add("dbname", viper.GetString(name+".db"))
+
+	if mode := strings.ToLower(viper.GetString(name + ".tls.mode")); mode != "" {
+		sslMode, ok := postgresSSLModes[mode]
+		if !ok {
+			return "", fmt.Errorf("%s connection: unknown TLS mode %q, expected one of: %s", name, mode, strings.Join(database.TLSModes, ", "))
+		}
+
+		add("sslmode", sslMode)
+	}
+
+	add("sslrootcert", viper.GetString(name+".tls.ca"))
+	add("sslcert", viper.GetString(name+".tls.cert"))
+	add("sslkey", viper.GetString(name+".tls.key"))
+
+	if viper.GetString(name+".tls.serverName") != "" {
+		return "", fmt.Errorf("%s connection: tls.serverName is not supported for PostgreSQL, the certificate is verified against the host", name)
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+// sqliteDSN returns the database file of an SQLite connection, set with
+// <name>.db. Only the destination is created when the file doesn't exist, so
+// a mistyped source isn't archived from as an empty database.
+func sqliteDSN(name string) (string, error) {
+	file := viper.GetString(name + ".db")
+	if err := helpers.AssertError(file != "", fmt.Sprintf("Expected %s.db to be set to the database file", name)); err != nil {
+		return "", err
+	}
+
+	if name == "source" {
+		if _, err := os.Stat(database.DatabaseName(database.SQLite, file)); err != nil {
+			return "", fmt.Errorf("source connection: %v", err)
+		}
+	}
+
+	return file, nil
+}
+
+// optionValue returns the value of key when archi.json sets it, otherwise the
+// option from the MySQL option files, otherwise the default of key.
+func optionValue(opts mycnf.Options, key string, option string) string {
+	if viper.InConfig(key) && viper.GetString(key) != "" {
+		return viper.GetString(key)
+	}
+
+	if value, ok := opts.Get(option); ok {
+		return value
+	}
+
+	return viper.GetString(key)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// readConfig loads archi.json content into viper for the test
+func readConfig(t *testing.T, content string) {
+	t.Helper()
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.SetConfigType("json")
+	if err := viper.ReadConfig(strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresDSNSocket(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name: "host and a global socket",
+			config: `{"socket": "/run/mysqld/mysqld.sock",
+				"source": {"engine": "postgres", "host": "db.example.com", "port": 5432, "user": "archi", "db": "shop"}}`,
+			want: "host='db.example.com' port='5432' user='archi' dbname='shop'",
+		},
+		{
+			name: "no host and a global socket",
+			config: `{"socket": "/run/postgresql/.s.PGSQL.5432",
+				"source": {"engine": "postgres", "user": "archi", "db": "shop"}}`,
+			want: "host='/run/postgresql' user='archi' dbname='shop'",
+		},
+		{
+			name: "host, unix protocol and a global socket",
+			config: `{"socket": "/run/postgresql",
+				"source": {"engine": "postgres", "host": "db.example.com", "protocol": "unix", "db": "shop"}}`,
+			want: "host='/run/postgresql' dbname='shop'",
+		},
+		{
+			name: "socket of the connection",
+			config: `{"socket": "/run/mysqld/mysqld.sock",
+				"source": {"engine": "postgres", "host": "db.example.com", "socket": "/run/postgresql", "db": "shop"}}`,
+			want: "host='/run/postgresql' dbname='shop'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readConfig(t, tt.config)
+
+			dsn, err := postgresDSN("source")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dsn != tt.want {
+				t.Errorf("postgresDSN = %s, want %s", dsn, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/fn3x/archivator/internal/job"
+	"github.com/fn3x/archivator/internal/metrics"
+	"github.com/fn3x/archivator/internal/scheduler"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run archive jobs on their schedules",
+	Long: `
+Run the jobs of a jobs file on their cron schedules inside one process.
+
+A job is not started while its previous run is still going. SIGHUP reloads the
+jobs file, SIGTERM and SIGINT stop the daemon after the batches in progress
+are finished. A second SIGTERM or SIGINT exits immediately.
+
+With --metrics-addr the Prometheus metrics of the runs are served on /metrics.`,
+	Args: cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("%+v\n\n%s", err, "To create config file:\n  archi config")
+		}
+
+		jobsFile, err := cmd.Flags().GetString("jobs")
+		if err != nil {
+			return err
+		}
+
+		jobs, err := job.LoadSet(jobsFile)
+		if err != nil {
+			return err
+		}
+
+		metricsAddr, err := cmd.Flags().GetString("metrics-addr")
+		if err != nil {
+			return err
+		}
+
+		db, err := connect("source")
+		if err != nil {
+			return fmt.Errorf("error connecting to DB: %+v", err)
+		}
+		defer db.Close()
+
+		var runMetrics *metrics.Metrics
+		if metricsAddr != "" {
+			runMetrics = metrics.New()
+
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", runMetrics.Handler())
+
+			server := &http.Server{Addr: metricsAddr, Handler: mux}
+			defer server.Close()
+
+			go func() {
+				if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					slog.Error("metrics server failed", "err", err)
+				}
+			}()
+
+			slog.Info("serving metrics", "addr", metricsAddr, "path", "/metrics")
+		}
+
+		runCtx, cancelRuns := context.WithCancel(context.Background())
+		defer cancelRuns()
+
+		sched := scheduler.New(runCtx)
+		sched.Replace(scheduleEntries(jobs, db, runMetrics))
+
+		slog.Info("daemon started", "jobs", len(jobs), "file", jobsFile)
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				jobs, err := job.LoadSet(jobsFile)
+				if err != nil {
+					slog.Error("couldn't reload the jobs, keeping the current ones", "file", jobsFile, "err", err)
+					continue
+				}
+
+				sched.Replace(scheduleEntries(jobs, db, runMetrics))
+				slog.Info("reloaded jobs", "jobs", len(jobs), "file", jobsFile)
+
+				continue
+			}
+
+			slog.Info("stopping after the batches in progress", "signal", sig.String())
+			cancelRuns()
+
+			go func() {
+				<-signals
+				slog.Warn("received second signal, exiting")
+				os.Exit(1)
+			}()
+
+			sched.Stop()
+			slog.Info("daemon stopped")
+
+			return nil
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	daemonCmd.Flags().String("jobs", "", "jobs file with a schedule for every job")
+	daemonCmd.Flags().String("metrics-addr", "", "address to serve Prometheus metrics on, e.g. :9108")
+	daemonCmd.MarkFlagRequired("jobs")
+
+	rootCmd.AddCommand(daemonCmd)
+}
+
+func scheduleEntries(jobs []*job.Job, db *sql.DB, runMetrics *metrics.Metrics) []scheduler.Entry {
+	entries := make([]scheduler.Entry, 0, len(jobs))
+
+	for _, j := range jobs {
+		// LoadSet has already checked the schedule
+		schedule, _ := cron.ParseStandard(j.Schedule)
+
+		entries = append(entries, scheduler.Entry{
+			Name:     j.Name,
+			Schedule: schedule,
+			Run: func(ctx context.Context) {
+				runJob(ctx, j, db, runMetrics)
+			},
+		})
+	}
+
+	return entries
+}
+
+func runJob(ctx context.Context, j *job.Job, db *sql.DB, runMetrics *metrics.Metrics) {
+	logger := slog.Default().With("job", j.Name)
+
+	startedAt := time.Now()
+	logger.Info("job started")
+
+	archiveConfig, err := j.ArchiveConfig(startedAt)
+	if err != nil {
+		if runMetrics != nil {
+			runMetrics.Finished(j.Name, err)
+		}
+
+		logger.Error("job failed", "err", err)
+		return
+	}
+
+	runArchiver := archiver.New(db)
+	runArchiver.Logger = logger
+
+	if archiveConfig.OutputDir == "" {
+		archiveConfig.OutputDir = viper.GetString("outputDir")
+	}
+
+	if runMetrics != nil {
+		runArchiver.Observer = runMetrics.Observer(j.Name)
+	}
+
+	recorder, closeHistory, err := historyRecorder(db, "job:"+j.Name)
+	if err != nil {
+		if runMetrics != nil {
+			runMetrics.Finished(j.Name, err)
+		}
+
+		logger.Error("job failed", "err", err)
+		return
+	}
+	defer closeHistory()
+
+	if recorder != nil {
+		runArchiver.Observer = archiver.Observers(runArchiver.Observer, recorder)
+	}
+
+	runArchiver.Sink, err = openSink(sinkOptions(j.Sink))
+	if err != nil {
+		if runMetrics != nil {
+			runMetrics.Finished(j.Name, err)
+		}
+
+		logger.Error("job failed", "err", err)
+		return
+	}
+	defer runArchiver.Sink.Close()
+
+	_, err = runArchiver.Archive(archiveConfig, ctx)
+
+	if runMetrics != nil {
+		runMetrics.Finished(j.Name, err)
+	}
+
+	if err != nil {
+		logger.Error("job failed", "duration", time.Since(startedAt), "err", err)
+		return
+	}
+
+	logger.Info("job finished", "duration", time.Since(startedAt))
+}
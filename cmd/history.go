@@ -0,0 +1,209 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/fn3x/archivator/internal/cutoff"
+	"github.com/fn3x/archivator/internal/history"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List past archive runs",
+	Long: `
+List the runs recorded in the archi_runs table, the latest first.
+
+Runs are recorded when the history config key is set to the database to keep
+them in:
+
+  archi config set history source       # or destination, off`,
+	Args: cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("%+v\n\n%s", err, "To create config file:\n  archi config")
+		}
+
+		var filter history.Filter
+		var err error
+
+		if filter.Table, err = cmd.Flags().GetString("table"); err != nil {
+			return err
+		}
+
+		if filter.RunID, err = cmd.Flags().GetString("run"); err != nil {
+			return err
+		}
+
+		if filter.Status, err = cmd.Flags().GetString("status"); err != nil {
+			return err
+		}
+
+		if filter.Limit, err = cmd.Flags().GetUint64("limit"); err != nil {
+			return err
+		}
+
+		since, err := cmd.Flags().GetString("since")
+		if err != nil {
+			return err
+		}
+
+		until, err := cmd.Flags().GetString("until")
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		if since != "" {
+			if filter.Since, err = cutoff.Parse(since, now, time.UTC); err != nil {
+				return fmt.Errorf("--since: %v", err)
+			}
+		}
+
+		if until != "" {
+			if filter.Until, err = cutoff.Parse(until, now, time.UTC); err != nil {
+				return fmt.Errorf("--until: %v", err)
+			}
+		}
+
+		name, err := cmd.Flags().GetString("db")
+		if err != nil {
+			return err
+		}
+
+		if name == "" {
+			name = historyDatabase()
+		}
+
+		if name == "" {
+			name = "source"
+		}
+
+		if name != "source" && name != "destination" {
+			return fmt.Errorf("unknown database %q, expected source or destination", name)
+		}
+
+		db, err := connect(name)
+		if err != nil {
+			return fmt.Errorf("error connecting to DB: %+v", err)
+		}
+		defer db.Close()
+
+		records, err := history.List(db, filter)
+		if err != nil {
+			return err
+		}
+
+		if len(records) == 0 {
+			fmt.Println("No runs found")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RUN\tSTARTED\tTABLE\tCUTOFF\tARCHIVED\tDELETED\tSTATUS\tOPERATOR\tSOURCE\tOUTPUT")
+
+		for _, r := range records {
+			status := r.Status
+			if r.Error != "" {
+				status += ": " + strings.ReplaceAll(r.Error, "\n", " ")
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s@%s\t%s\t%s\n",
+				r.RunID,
+				r.StartedAt.Format(time.DateTime),
+				r.Table,
+				r.CutoffDate.Format(time.DateTime),
+				r.RowsArchived,
+				r.RowsDeleted,
+				status,
+				r.Operator,
+				r.Host,
+				r.Source,
+				r.Output,
+			)
+		}
+
+		return w.Flush()
+	},
+}
+
+func init() {
+	historyCmd.Flags().String("table", "", "only runs of the table")
+	historyCmd.Flags().String("run", "", "only the run with the id")
+	historyCmd.Flags().String("status", "", "only runs with the status: running, succeeded, failed or cancelled")
+	historyCmd.Flags().String("since", "", "only runs started at or after: 2025-04-01, now-90d")
+	historyCmd.Flags().String("until", "", "only runs started before: 2025-07-01, today")
+	historyCmd.Flags().Uint64("limit", 20, "how many records to list, 0 for all")
+	historyCmd.Flags().String("db", "", "database with the history: source or destination (default: the history config key)")
+
+	rootCmd.AddCommand(historyCmd)
+}
+
+// historyDatabase returns the connection the history is kept in, source or
+// destination, or "" when runs aren't recorded
+func historyDatabase() string {
+	name := strings.ToLower(viper.GetString("history"))
+	if name == "off" {
+		return ""
+	}
+
+	return name
+}
+
+// historyRecorder returns the recorder of runs started with source, or nil
+// when runs aren't recorded. The history is kept in sourceDB or in the
+// destination database, which is connected to and closed by close.
+func historyRecorder(sourceDB *sql.DB, source string) (recorder *history.Recorder, close func(), err error) {
+	close = func() {}
+
+	var db *sql.DB
+
+	switch historyDatabase() {
+	case "":
+		return nil, close, nil
+	case "source":
+		db = sourceDB
+	case "destination":
+		db, err = connect("destination")
+		if err != nil {
+			return nil, close, fmt.Errorf("error connecting to the history DB: %+v", err)
+		}
+
+		close = func() { db.Close() }
+	default:
+		return nil, close, fmt.Errorf("unknown history database %q, expected off, source or destination", viper.GetString("history"))
+	}
+
+	if err := history.EnsureTable(db); err != nil {
+		close()
+		return nil, func() {}, fmt.Errorf("couldn't create the %s table: %v", history.Table, err)
+	}
+
+	return history.NewRecorder(db, source), close, nil
+}
+
+// formatCode returns the tables in the format of --code
+func formatCode(tables []archiver.Table) string {
+	var b strings.Builder
+
+	for _, table := range tables {
+		if table.RefTable == "" {
+			fmt.Fprintf(&b, "m:%s:%s;", table.Name, table.TimestampCol)
+		} else {
+			fmt.Fprintf(&b, "r:%s:%s:%s:%s;", table.Name, table.RefTable, table.RefColumn, table.RefTimestampCol)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,82 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/spf13/cobra"
+)
+
+var holdCmd = &cobra.Command{
+	Use:   "hold <archive>...",
+	Short: "Put archive files under legal hold, which archi prune never removes",
+	Long: `
+Put archive files under legal hold with the reason, recorded in the catalog of
+their directory. archi prune keeps archives under legal hold whatever their
+age, until they are released:
+
+  archi hold --reason="case 2025-114" /var/archive/archived_orders_till_*.csv
+  archi hold --release /var/archive/archived_orders_till_2019-01-01T00:00:00Z_at_2019-01-02T03:00:00Z.csv`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reason, err := cmd.Flags().GetString("reason")
+		if err != nil {
+			return err
+		}
+
+		release, err := cmd.Flags().GetBool("release")
+		if err != nil {
+			return err
+		}
+
+		if release == (reason != "") {
+			return fmt.Errorf("set either --reason or --release")
+		}
+
+		for _, path := range args {
+			table, ok := archiver.ParseArchiveName(path)
+			if !ok {
+				return fmt.Errorf("%s isn't named like an archive", path)
+			}
+
+			dir, file := filepath.Dir(path), filepath.Base(path)
+
+			err := archiver.UpdateCatalog(dir, func(catalog *archiver.Catalog) error {
+				entry, ok := catalog.Entry(file)
+				if !ok {
+					var err error
+					if entry, err = archiver.IndexArchive(path, catalogedTimestampCol(catalog, table.Name)); err != nil {
+						return err
+					}
+				}
+
+				entry.Hold = reason
+				catalog.Put(entry)
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if release {
+				fmt.Printf("%s: released\n", path)
+			} else {
+				fmt.Printf("%s: under legal hold\n", path)
+			}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	holdCmd.Flags().String("reason", "", "why the archives are held, e.g. a case number")
+	holdCmd.Flags().Bool("release", false, "release the archives from legal hold")
+
+	rootCmd.AddCommand(holdCmd)
+}
@@ -0,0 +1,313 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/fn3x/archivator/internal/cutoff"
+	database "github.com/fn3x/archivator/internal/db"
+	"github.com/fn3x/archivator/internal/history"
+	"github.com/fn3x/archivator/internal/progress"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Report the tables of the source database worth archiving",
+	Long: `
+Report the size and estimated rows of every table of the source database, its
+date and time columns with their oldest and newest values and whether they are
+indexed, and the foreign keys from and to it. Tables with rows older than
+--older-than get a suggested --code.
+
+The range of a column that leads no index is only read with --scan-unindexed,
+since it takes a scan of the whole table:
+
+  archi inspect --older-than=1y
+  archi inspect --table=orders --table=order_items --scan-unindexed`,
+	Args: cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("%+v\n\n%s", err, "To create config file:\n  archi config")
+		}
+
+		olderThan, err := cmd.Flags().GetString("older-than")
+		if err != nil {
+			return err
+		}
+
+		only, err := cmd.Flags().GetStringSlice("table")
+		if err != nil {
+			return err
+		}
+
+		scanUnindexed, err := cmd.Flags().GetBool("scan-unindexed")
+		if err != nil {
+			return err
+		}
+
+		cutoffDate, err := cutoff.Resolve("", olderThan, time.Now(), time.UTC)
+		if err != nil {
+			return err
+		}
+
+		db, err := connect("source")
+		if err != nil {
+			return fmt.Errorf("error connecting to DB: %+v", err)
+		}
+		defer db.Close()
+
+		tables, err := inspectTables(db, only, scanUnindexed)
+		if err != nil {
+			return err
+		}
+
+		if len(tables) == 0 {
+			fmt.Println("No tables found")
+			return nil
+		}
+
+		for _, table := range tables {
+			printInspectedTable(os.Stdout, table)
+		}
+
+		codes := suggestCodes(tables, cutoffDate)
+		if len(codes) == 0 {
+			fmt.Printf("No table has rows older than %s\n", olderThan)
+		}
+
+		unread := slices.ContainsFunc(tables, func(table inspectedTable) bool {
+			return slices.ContainsFunc(table.columns, func(column inspectedColumn) bool { return !column.indexed && !column.scanned && column.rangeErr == nil })
+		})
+
+		if unread {
+			fmt.Println("Columns that lead no index weren't looked at, read them with --scan-unindexed")
+		}
+
+		if len(codes) == 0 {
+			return nil
+		}
+
+		fmt.Printf("\nTables with rows older than %s:\n", olderThan)
+		for _, table := range codes {
+			fmt.Printf("  %s\n", strings.TrimSuffix(formatCode([]archiver.Table{table}), ";"))
+		}
+
+		fmt.Printf("\nRun them with:\n  archi ve --code='%s' --older-than=%s\n", formatCode(codes), olderThan)
+
+		return nil
+	},
+}
+
+func init() {
+	inspectCmd.Flags().String("older-than", "90d", "age of the rows worth archiving: 90d, 6mo, 1y")
+	inspectCmd.Flags().StringSlice("table", nil, "only the table, can be repeated")
+	inspectCmd.Flags().Bool("scan-unindexed", false, "read the range of columns that lead no index, scanning their tables")
+
+	rootCmd.AddCommand(inspectCmd)
+}
+
+// inspectedTable is what inspect reports about a table
+type inspectedTable struct {
+	database.TableSize
+	columns  []inspectedColumn
+	parents  []database.ForeignKey
+	children []database.ForeignKey
+}
+
+// inspectedColumn is a date or time column of a table, with the range of its
+// values when it was read
+type inspectedColumn struct {
+	database.TimestampColumn
+	indexed bool
+	scanned bool
+	oldest  time.Time
+	newest  time.Time
+	// rangeErr is why the range couldn't be read, e.g. values that aren't
+	// timestamps
+	rangeErr error
+}
+
+// inspectTables reads the catalog of the source database and the ranges of
+// the date and time columns, of the unindexed ones only with scanUnindexed.
+// only narrows the tables down when it is not empty.
+func inspectTables(db *sql.DB, only []string, scanUnindexed bool) ([]inspectedTable, error) {
+	sizes, err := database.TableSizes(db)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the tables: %v", err)
+	}
+
+	columns, err := database.TimestampColumns(db)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the columns: %v", err)
+	}
+
+	indexed, err := database.IndexedColumns(db)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the indexes: %v", err)
+	}
+
+	keys, err := database.ForeignKeys(db)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the foreign keys: %v", err)
+	}
+
+	var tables []inspectedTable
+	for _, size := range sizes {
+		if size.Name == history.Table || len(only) > 0 && !slices.Contains(only, size.Name) {
+			continue
+		}
+
+		table := inspectedTable{TableSize: size}
+
+		for _, column := range columns {
+			if column.Table != size.Name {
+				continue
+			}
+
+			inspected := inspectedColumn{TimestampColumn: column, indexed: slices.Contains(indexed[size.Name], column.Column)}
+
+			if inspected.indexed || scanUnindexed {
+				inspected.oldest, inspected.newest, _, inspected.rangeErr = database.ColumnRange(db, size.Name, column.Column)
+				inspected.scanned = inspected.rangeErr == nil
+			}
+
+			table.columns = append(table.columns, inspected)
+		}
+
+		for _, key := range keys {
+			if key.Table == size.Name {
+				table.parents = append(table.parents, key)
+			}
+
+			if key.RefTable == size.Name {
+				table.children = append(table.children, key)
+			}
+		}
+
+		tables = append(tables, table)
+	}
+
+	for _, name := range only {
+		if !slices.ContainsFunc(tables, func(table inspectedTable) bool { return table.Name == name }) {
+			return nil, fmt.Errorf("table %s not found", name)
+		}
+	}
+
+	return tables, nil
+}
+
+func printInspectedTable(out io.Writer, table inspectedTable) {
+	fmt.Fprintf(out, "%s: ~%d rows, %s\n", table.Name, table.Rows, progress.FormatBytes(table.Bytes))
+
+	if len(table.columns) == 0 {
+		fmt.Fprintln(out, "  no date or time columns")
+	}
+
+	for _, column := range table.columns {
+		index := "not indexed"
+		if column.indexed {
+			index = "indexed"
+		}
+
+		valueRange := "range not read"
+		switch {
+		case column.rangeErr != nil:
+			valueRange = fmt.Sprintf("range not read: %v", column.rangeErr)
+		case column.scanned && column.oldest.IsZero():
+			valueRange = "no values"
+		case column.scanned:
+			valueRange = fmt.Sprintf("%s .. %s", column.oldest.Format(time.DateTime), column.newest.Format(time.DateTime))
+		}
+
+		fmt.Fprintf(out, "  %s %s, %s, %s\n", column.Column, column.Type, index, valueRange)
+	}
+
+	for _, key := range table.parents {
+		fmt.Fprintf(out, "  references %s.%s through %s\n", key.RefTable, key.RefColumn, key.Column)
+	}
+
+	for _, key := range table.children {
+		fmt.Fprintf(out, "  referenced by %s.%s\n", key.Table, key.Column)
+	}
+
+	fmt.Fprintln(out)
+}
+
+// suggestCodes suggests archiving the tables with rows older than the cutoff
+// by their best timestamp column, and the tables without one by the column of
+// a table they reference by id. Tables archived by a reference come first, so
+// a purge deletes them before the rows they reference.
+func suggestCodes(tables []inspectedTable, cutoffDate time.Time) []archiver.Table {
+	byColumn := map[string]archiver.Table{}
+	var main, related []archiver.Table
+
+	for _, table := range tables {
+		column, ok := archiveColumn(table)
+		if !ok || !column.scanned || column.oldest.IsZero() || !column.oldest.Before(cutoffDate) {
+			continue
+		}
+
+		suggested := archiver.Table{Name: table.Name, TimestampCol: column.Column}
+		byColumn[table.Name] = suggested
+		main = append(main, suggested)
+	}
+
+	for _, table := range tables {
+		if len(table.columns) > 0 {
+			continue
+		}
+
+		for _, key := range table.parents {
+			parent, ok := byColumn[key.RefTable]
+			if !ok || key.RefColumn != "id" {
+				continue
+			}
+
+			related = append(related, archiver.Table{Name: table.Name, RefTable: parent.Name, RefColumn: key.Column, RefTimestampCol: parent.TimestampCol})
+			break
+		}
+	}
+
+	return slices.Concat(related, main)
+}
+
+// archiveColumn picks the column to archive the table by: an indexed one
+// over one that isn't, a creation time over any other
+func archiveColumn(table inspectedTable) (inspectedColumn, bool) {
+	if len(table.columns) == 0 {
+		return inspectedColumn{}, false
+	}
+
+	score := func(column inspectedColumn) int {
+		s := 0
+		if column.indexed {
+			s += 2
+		}
+
+		if strings.Contains(strings.ToLower(column.Column), "created") {
+			s++
+		}
+
+		return s
+	}
+
+	best := table.columns[0]
+	for _, column := range table.columns[1:] {
+		if score(column) > score(best) {
+			best = column
+		}
+	}
+
+	return best, true
+}
@@ -0,0 +1,72 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	database "github.com/fn3x/archivator/internal/db"
+	"github.com/fn3x/archivator/internal/job"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var jobCmd = &cobra.Command{
+	Use:   "job",
+	Short: "Work with job files",
+}
+
+var jobValidateCmd = &cobra.Command{
+	Use:   "validate <job-file>",
+	Short: "Check a job file against the source database schema",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		archiveJob, err := job.Load(args[0])
+		if err != nil {
+			return err
+		}
+
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("%+v\n\n%s", err, "To create config file:\n  archi config")
+		}
+
+		db, err := connect("source")
+		if err != nil {
+			return fmt.Errorf("error connecting to DB: %+v", err)
+		}
+		defer db.Close()
+
+		// the cutoff doesn't matter to the schema, and a job given its
+		// cutoff with ve --cutoff has none
+		if archiveJob.Cutoff == "" && archiveJob.OlderThan == "" {
+			archiveJob.Cutoff = "now"
+		}
+
+		config, err := archiveJob.ArchiveConfig(time.Now())
+		if err != nil {
+			return fmt.Errorf("%s: %v", args[0], err)
+		}
+
+		// the tables as the run sees them, so the job-wide where is
+		// checked for the tables without one of their own
+		tables := make([]database.Table, len(config.Tables))
+		for i, table := range config.Tables {
+			tables[i] = table.WithDefaults(config.CutoffDate, config.Limit, config.Where, config.OutputDir)
+		}
+
+		if err := database.ValidateTables(db, tables); err != nil {
+			return fmt.Errorf("%s: %v", args[0], err)
+		}
+
+		fmt.Printf("%s is valid: %d table(s)\n", args[0], len(archiveJob.Tables))
+
+		return nil
+	},
+}
+
+func init() {
+	jobCmd.AddCommand(jobValidateCmd)
+	rootCmd.AddCommand(jobCmd)
+}
@@ -0,0 +1,230 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/fn3x/archivator/internal/cutoff"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the archive files in the catalog of the output directory",
+	Long: `
+List the archive files recorded in the catalog of the output directory,
+archi-catalog.json, with their ids, timestamps, rows and checksum. The csv and
+jsonl sinks add every file they write to the catalog of its directory.
+
+Find the archive holding a row, or the archives of a period:
+
+  archi ls --table=orders --id=123456
+  archi ls --table=orders --since=2024-01-01 --until=2024-07-01
+
+Archives written before the catalog was are added with --index, which reads
+them all once:
+
+  archi ls --index`,
+	Args: cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var filter archiver.CatalogFilter
+		var err error
+
+		if filter.Table, err = cmd.Flags().GetString("table"); err != nil {
+			return err
+		}
+
+		if filter.ID, err = cmd.Flags().GetUint64("id"); err != nil {
+			return err
+		}
+
+		since, err := cmd.Flags().GetString("since")
+		if err != nil {
+			return err
+		}
+
+		until, err := cmd.Flags().GetString("until")
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		if since != "" {
+			if filter.Since, err = cutoff.Parse(since, now, time.UTC); err != nil {
+				return fmt.Errorf("--since: %v", err)
+			}
+		}
+
+		if until != "" {
+			if filter.Until, err = cutoff.Parse(until, now, time.UTC); err != nil {
+				return fmt.Errorf("--until: %v", err)
+			}
+		}
+
+		index, err := cmd.Flags().GetBool("index")
+		if err != nil {
+			return err
+		}
+
+		dir, err := catalogDir(cmd)
+		if err != nil {
+			return err
+		}
+
+		if index {
+			indexed, err := indexArchives(dir)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Added %d archives to the catalog\n", indexed)
+		}
+
+		catalog, err := archiver.LoadCatalog(dir)
+		if err != nil {
+			return err
+		}
+
+		entries := catalog.Find(filter)
+		if len(entries) == 0 {
+			fmt.Println("No archives found")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tTABLE\tROWS\tIDS\tOLDEST\tNEWEST\tRUN\tSHA256\tHOLD")
+
+		for _, entry := range entries {
+			ids, oldest, newest := "-", "-", "-"
+			if entry.IDs != nil {
+				ids = fmt.Sprintf("%d..%d", entry.IDs.Min, entry.IDs.Max)
+			}
+
+			if entry.Timestamps != nil {
+				oldest = entry.Timestamps.Oldest.Format(time.DateTime)
+				newest = entry.Timestamps.Newest.Format(time.DateTime)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%.12s\t%s\n",
+				entry.File,
+				entry.Table,
+				entry.Rows,
+				ids,
+				oldest,
+				newest,
+				orDash(entry.RunID),
+				entry.Checksum,
+				orDash(entry.Hold),
+			)
+		}
+
+		return w.Flush()
+	},
+}
+
+func init() {
+	lsCmd.Flags().String("table", "", "only archives of the table")
+	lsCmd.Flags().Uint64("id", 0, "only archives whose ids range over the id")
+	lsCmd.Flags().String("since", "", "only archives with rows at or after: 2025-04-01, now-90d")
+	lsCmd.Flags().String("until", "", "only archives with rows before: 2025-07-01, today")
+	lsCmd.Flags().String("dir", "", "directory of the catalog (default: the outputDir config key)")
+	lsCmd.Flags().Bool("index", false, "add the archives of the directory missing from the catalog")
+
+	rootCmd.AddCommand(lsCmd)
+}
+
+// catalogDir returns the directory set with --dir or the output directory of
+// the config
+func catalogDir(cmd *cobra.Command) (string, error) {
+	dir, err := cmd.Flags().GetString("dir")
+	if err != nil || dir != "" {
+		return dir, err
+	}
+
+	if err := viper.ReadInConfig(); err != nil {
+		return "", fmt.Errorf("%+v\n\n%s", err, "To create config file:\n  archi config\nor set --dir")
+	}
+
+	return viper.GetString("outputDir"), nil
+}
+
+// indexArchives adds the archive files of the directory missing from its
+// catalog and returns how many it added. Their timestamp range is read from
+// the column the latest archive of their table in the catalog was archived by.
+func indexArchives(dir string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "archived_*"))
+	if err != nil {
+		return 0, err
+	}
+
+	indexed := 0
+
+	err = archiver.UpdateCatalog(dir, func(catalog *archiver.Catalog) error {
+		for _, path := range paths {
+			if _, ok := catalog.Entry(filepath.Base(path)); ok {
+				continue
+			}
+
+			table, ok := archiver.ParseArchiveName(path)
+			if !ok {
+				continue
+			}
+
+			entry, err := archiver.IndexArchive(path, catalogedTimestampCol(catalog, table.Name))
+			if err != nil {
+				return err
+			}
+
+			catalog.Put(entry)
+			indexed++
+		}
+
+		return nil
+	})
+
+	return indexed, err
+}
+
+// catalogedTimestampCol returns the column the latest archive of the table in
+// the catalog was archived by
+func catalogedTimestampCol(catalog *archiver.Catalog, table string) string {
+	timestampCol := ""
+	for _, entry := range catalog.Find(archiver.CatalogFilter{Table: table}) {
+		if entry.TimestampCol != "" {
+			timestampCol = entry.TimestampCol
+		}
+	}
+
+	return timestampCol
+}
+
+// uncatalogedArchives counts the archive files of the directory missing from
+// the catalog
+func uncatalogedArchives(dir string, catalog *archiver.Catalog) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "archived_*"))
+	if err != nil {
+		return 0, err
+	}
+
+	missing := 0
+	for _, path := range paths {
+		if _, ok := archiver.ParseArchiveName(path); !ok {
+			continue
+		}
+
+		if _, ok := catalog.Entry(filepath.Base(path)); !ok {
+			missing++
+		}
+	}
+
+	return missing, nil
+}
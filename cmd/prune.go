@@ -0,0 +1,242 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/fn3x/archivator/internal/cutoff"
+	"github.com/fn3x/archivator/internal/job"
+	"github.com/fn3x/archivator/internal/progress"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove archive files older than their retention",
+	Long: `
+Delete the archive files in the catalog whose rows are all older than the
+retention, or move them to another directory with --move-to. The age of an
+archive is its newest row, or the cutoff of its run when the catalog doesn't
+know its timestamps.
+
+--keep applies to every table without a retention of its own. Tables of the
+jobs of --job and --jobs are kept for the keep of the table or of the job, in
+their output directory. A table several jobs keep in the same directory is kept
+for the longest of their retentions:
+
+  archi prune --keep=7y --dry-run
+  archi prune --jobs=jobs.yaml --move-to=/mnt/cold
+
+Archives under legal hold are never touched, see archi hold. Archive files
+missing from the catalog are left alone too, add them with archi ls --index.`,
+	Args: cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keep, err := cmd.Flags().GetString("keep")
+		if err != nil {
+			return err
+		}
+
+		only, err := cmd.Flags().GetStringSlice("table")
+		if err != nil {
+			return err
+		}
+
+		moveTo, err := cmd.Flags().GetString("move-to")
+		if err != nil {
+			return err
+		}
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+
+		jobs, err := pruneJobs(cmd)
+		if err != nil {
+			return err
+		}
+
+		dir, err := catalogDir(cmd)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		var defaultCutoff time.Time
+		if keep != "" {
+			if defaultCutoff, err = cutoff.OlderThan(keep, now); err != nil {
+				return fmt.Errorf("--keep: %v", err)
+			}
+		}
+
+		// cutoffs of the tables by the directory of their archives
+		cutoffs := map[string]map[string]time.Time{dir: {}}
+
+		for _, j := range jobs {
+			for _, retention := range j.Retentions() {
+				tableDir := retention.OutputDir
+				if tableDir == "" {
+					tableDir = dir
+				}
+
+				tableCutoff, err := cutoff.OlderThan(retention.Keep, now)
+				if err != nil {
+					return fmt.Errorf("job %s: table %s: %v", j.Name, retention.Table, err)
+				}
+
+				if cutoffs[tableDir] == nil {
+					cutoffs[tableDir] = map[string]time.Time{}
+				}
+
+				if kept, ok := cutoffs[tableDir][retention.Table]; ok && kept.Before(tableCutoff) {
+					continue
+				}
+
+				cutoffs[tableDir][retention.Table] = tableCutoff
+			}
+		}
+
+		if keep == "" && len(cutoffs) == 1 && len(cutoffs[dir]) == 0 {
+			return fmt.Errorf("set --keep or the keep of the tables with --job or --jobs")
+		}
+
+		if moveTo != "" {
+			info, err := os.Stat(moveTo)
+			if err != nil {
+				return fmt.Errorf("--move-to: %v", err)
+			}
+
+			if !info.IsDir() {
+				return fmt.Errorf("--move-to: %s is not a directory", moveTo)
+			}
+		}
+
+		action := "deleted"
+		if moveTo != "" {
+			action = "moved"
+		}
+
+		if dryRun {
+			action = "would be " + action
+		}
+
+		var pruned, held, failed int
+		var bytes int64
+
+		for _, archiveDir := range slices.Sorted(maps.Keys(cutoffs)) {
+			catalog, err := archiver.LoadCatalog(archiveDir)
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range catalog.Archives {
+				if len(only) > 0 && !slices.Contains(only, entry.Table) {
+					continue
+				}
+
+				tableCutoff, ok := cutoffs[archiveDir][entry.Table]
+				if !ok {
+					tableCutoff = defaultCutoff
+				}
+
+				if tableCutoff.IsZero() || !entry.Expired(tableCutoff) {
+					continue
+				}
+
+				if entry.Hold != "" {
+					fmt.Printf("%s: kept, under legal hold: %s\n", entry.File, entry.Hold)
+					held++
+					continue
+				}
+
+				if !dryRun {
+					if err := archiver.PruneArchive(archiveDir, entry.File, moveTo); err != nil {
+						fmt.Printf("%s: %v\n", entry.File, err)
+						failed++
+						continue
+					}
+				}
+
+				fmt.Printf("%s: %s, newest row %s\n", entry.File, action, entry.Newest().Format(time.DateTime))
+				pruned++
+				bytes += entry.Bytes
+			}
+
+			missing, err := uncatalogedArchives(archiveDir, catalog)
+			if err != nil {
+				return err
+			}
+
+			if missing > 0 {
+				fmt.Printf("%d archive files in %s aren't in the catalog, add them with: archi ls --index --dir=%s\n", missing, archiveDir, archiveDir)
+			}
+		}
+
+		fmt.Printf("%d archives %s, %s", pruned, action, progress.FormatBytes(bytes))
+		if held > 0 {
+			fmt.Printf(", %d under legal hold kept", held)
+		}
+		fmt.Println()
+
+		if failed > 0 {
+			return fmt.Errorf("%d archives couldn't be pruned", failed)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	pruneCmd.Flags().String("keep", "", "retention of the tables without one of their own: 90d, 6mo, 7y")
+	pruneCmd.Flags().String("job", "", "job file with the retention of its tables")
+	pruneCmd.Flags().String("jobs", "", "jobs file of the daemon with the retention of their tables")
+	pruneCmd.Flags().StringSlice("table", nil, "only archives of the table, can be repeated")
+	pruneCmd.Flags().String("dir", "", "directory of the archives (default: the outputDir config key)")
+	pruneCmd.Flags().String("move-to", "", "move expired archives to the directory instead of deleting them")
+	pruneCmd.Flags().Bool("dry-run", false, "only list the archives that would be pruned")
+
+	rootCmd.AddCommand(pruneCmd)
+}
+
+// pruneJobs loads the jobs of --job and --jobs
+func pruneJobs(cmd *cobra.Command) ([]*job.Job, error) {
+	jobFile, err := cmd.Flags().GetString("job")
+	if err != nil {
+		return nil, err
+	}
+
+	jobsFile, err := cmd.Flags().GetString("jobs")
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*job.Job
+
+	if jobFile != "" {
+		j, err := job.Load(jobFile)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	if jobsFile != "" {
+		set, err := job.LoadSet(jobsFile)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, set...)
+	}
+
+	return jobs, nil
+}
@@ -4,17 +4,36 @@ Copyright © 2025 fn3x <fn3x@proton.me>
 package cmd
 
 import (
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var rootCmd = &cobra.Command{
 	Use:     "archi",
-	Short:   "Archive MySQL tables",
-	Long:    `Cli tool to archive MySQL tables with timestamp columns as well as tables
-with foreign keys pointing to the tables containing timestamp columns`,
+	Short:   "Archive MySQL, PostgreSQL and SQLite tables",
+	Long:    `Cli tool to archive MySQL, PostgreSQL and SQLite tables with timestamp
+columns as well as tables with foreign keys pointing to the tables containing
+timestamp columns`,
 	Version: "1.0.0",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		level, err := cmd.Flags().GetString("log-level")
+		if err != nil {
+			return err
+		}
+
+		format, err := cmd.Flags().GetString("log-format")
+		if err != nil {
+			return err
+		}
+
+		return setupLogging(os.Stderr, level, format)
+	},
 }
 
 func Execute() {
@@ -25,4 +44,33 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().String("defaults-file", "", "read MySQL credentials only from this option file")
+	viper.BindPFlag("defaultsFile", rootCmd.PersistentFlags().Lookup("defaults-file"))
+
+	rootCmd.PersistentFlags().String("log-level", "info", "log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", "text", "log format: text or json")
+}
+
+// setupLogging sets the default slog logger writing to out
+func setupLogging(out io.Writer, level string, format string) error {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(out, options)
+	case "json":
+		handler = slog.NewJSONHandler(out, options)
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+
+	return nil
 }
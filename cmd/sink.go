@@ -0,0 +1,48 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/spf13/viper"
+)
+
+// sinkOptions returns the options of the sink config key with the options of
+// a job or the flags on top
+func sinkOptions(overrides map[string]string) map[string]string {
+	options := viper.GetStringMapString("sink")
+	if options == nil {
+		options = map[string]string{}
+	}
+
+	for key, value := range overrides {
+		if value != "" {
+			options[strings.ToLower(key)] = value
+		}
+	}
+
+	return options
+}
+
+// openSink creates the sink chosen by the type option. The database sink
+// writes to the destination connection.
+func openSink(options map[string]string) (archiver.Sink, error) {
+	options = maps.Clone(options)
+	name := options["type"]
+
+	if strings.EqualFold(name, "database") && options["dsn"] == "" {
+		engine, dsn, err := connectionDSN("destination")
+		if err != nil {
+			return nil, err
+		}
+
+		options["engine"] = engine
+		options["dsn"] = dsn
+	}
+
+	return archiver.NewSink(name, options)
+}
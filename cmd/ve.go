@@ -7,36 +7,25 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
-	database "github.com/fn3x/archivator/internal/db"
+	"github.com/fn3x/archivator/archiver"
+	"github.com/fn3x/archivator/internal/cutoff"
+	"github.com/fn3x/archivator/internal/filter"
 	"github.com/fn3x/archivator/internal/helpers"
-	"github.com/go-sql-driver/mysql"
+	"github.com/fn3x/archivator/internal/job"
+	"github.com/fn3x/archivator/internal/metrics"
+	"github.com/fn3x/archivator/internal/progress"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 )
 
-var layouts = []string{
-	time.RFC3339,
-	time.RFC3339Nano,
-	time.RFC822,
-	time.RFC822Z,
-	time.RFC850,
-	time.RFC1123,
-	time.RFC1123Z,
-	"2006-01-02 15:04:05",
-	"2006-01-02T15:04:05",
-	"2006-01-02",
-	"01/02/2006",
-	"01-02-2006",
-	"2006/01/02",
-	"Jan 2, 2006",
-	"January 2, 2006",
-	"2006-01-02 15:04:05.000000",
-}
-
 var veCmd = &cobra.Command{
 	Use:   "ve",
 	Short: "Archive tables",
@@ -45,6 +34,15 @@ var veCmd = &cobra.Command{
 			return fmt.Errorf("%+v\n\n%s", err, "To create config file:\n  archi config")
 		}
 
+		interactive, err := cmd.Flags().GetBool("interactive")
+		if err != nil {
+			return err
+		}
+
+		if interactive {
+			return runWizard(cmd)
+		}
+
 		table, err := cmd.Flags().GetString("table")
 		if err != nil {
 			return err
@@ -75,7 +73,17 @@ var veCmd = &cobra.Command{
 			return err
 		}
 
-		cutoff, err := cmd.Flags().GetTime("cutoff")
+		cutoffExpr, err := cmd.Flags().GetString("cutoff")
+		if err != nil {
+			return err
+		}
+
+		olderThan, err := cmd.Flags().GetString("older-than")
+		if err != nil {
+			return err
+		}
+
+		timezone, err := cmd.Flags().GetString("timezone")
 		if err != nil {
 			return err
 		}
@@ -90,68 +98,278 @@ var veCmd = &cobra.Command{
 			return err
 		}
 
-		dbConfig := mysql.NewConfig()
+		whereExpr, err := cmd.Flags().GetString("where")
+		if err != nil {
+			return err
+		}
+
+		jobFile, err := cmd.Flags().GetString("job")
+		if err != nil {
+			return err
+		}
 
-		dbConfig.DBName = viper.GetString("source.db")
-		dbConfig.Addr = fmt.Sprintf("%s:%d", viper.GetString("source.host"), viper.GetInt("source.port"))
-		dbConfig.User = viper.GetString("source.user")
-		dbConfig.Passwd = viper.GetString("source.password")
-		dbConfig.Net = "tcp"
+		batches, err := cmd.Flags().GetInt("batches")
+		if err != nil {
+			return err
+		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		metricsTextfile, err := cmd.Flags().GetString("metrics-textfile")
+		if err != nil {
+			return err
+		}
 
-		fmt.Print("Trying to connect to DB.. ")
-		db, err := database.ConnectDB(dbConfig, ctx)
+		lockTimeout, err := cmd.Flags().GetDuration("lock-timeout")
+		if err != nil {
+			return err
+		}
 
+		noProgress, err := cmd.Flags().GetBool("no-progress")
 		if err != nil {
-			fmt.Printf("Error connecting to DB: %+v", err)
+			return err
+		}
+
+		sinkType, err := cmd.Flags().GetString("sink")
+		if err != nil {
+			return err
+		}
+
+		var jobSink map[string]string
+
+		var runMetrics *metrics.Metrics
+		var observer archiver.Observer
+		metricsJob := "ve"
+
+		// what the run is recorded in the history as
+		runSource := code
+		if code == "" {
+			runSource = formatCode([]archiver.Table{{
+				Name:            table,
+				TimestampCol:    timestampCol,
+				RefTable:        relatedTable,
+				RefColumn:       relatedKey,
+				RefTimestampCol: relatedTimestampCol,
+			}})
+		}
+
+		if metricsTextfile != "" {
+			runMetrics = metrics.New()
+		}
+
+		var runProgress *progress.Progress
+		if !noProgress {
+			live := term.IsTerminal(int(os.Stderr.Fd()))
+			runProgress = progress.New(os.Stderr, live)
+
+			if live {
+				if err := setupLogging(runProgress, cmd.Flag("log-level").Value.String(), cmd.Flag("log-format").Value.String()); err != nil {
+					return err
+				}
+			}
+		}
+
+		var jobConfig *archiver.Config
+		if jobFile != "" {
+			archiveJob, err := job.Load(jobFile)
+			if err != nil {
+				return err
+			}
+
+			if cmd.Flags().Changed("cutoff") || cmd.Flags().Changed("older-than") {
+				archiveJob.Cutoff = cutoffExpr
+				archiveJob.OlderThan = olderThan
+			}
+
+			if cmd.Flags().Changed("timezone") {
+				archiveJob.Timezone = timezone
+			}
+
+			if cmd.Flags().Changed("where") {
+				archiveJob.Where = whereExpr
+			}
+
+			if cmd.Flags().Changed("limit") {
+				archiveJob.Limit = limit
+			}
+
+			if cmd.Flags().Changed("batches") {
+				archiveJob.Batches = batches
+			}
+
+			if cmd.Flags().Changed("lock-timeout") {
+				archiveJob.LockTimeout = lockTimeout
+			}
+
+			if purge {
+				archiveJob.Purge = true
+			}
+
+			if archiveJob.OutputDir == "" {
+				archiveJob.OutputDir = viper.GetString("outputDir")
+			}
+
+			jobSink = archiveJob.Sink
+
+			jobConfig, err = archiveJob.ArchiveConfig(time.Now())
+			if err != nil {
+				return fmt.Errorf("%s: %v", jobFile, err)
+			}
+
+			if archiveJob.Name != "" {
+				metricsJob = archiveJob.Name
+			}
+
+			runSource = "job:" + archiveJob.Name
+		}
+
+		if runMetrics != nil {
+			observer = runMetrics.Observer(metricsJob)
+		}
+
+		if runProgress != nil {
+			observer = archiver.Observers(observer, runProgress)
+		}
+
+		var where *filter.Filter
+		var cutoffDate time.Time
+
+		if jobConfig == nil {
+			if whereExpr != "" {
+				where, err = filter.Parse(whereExpr)
+				if err != nil {
+					return err
+				}
+			}
+
+			loc, err := time.LoadLocation(timezone)
+			if err != nil {
+				return fmt.Errorf("unknown timezone %q: %v", timezone, err)
+			}
+
+			cutoffDate, err = cutoff.Resolve(cutoffExpr, olderThan, time.Now(), loc)
+			if err != nil {
+				return err
+			}
+		}
+
+		options := sinkOptions(jobSink)
+		if sinkType != "" {
+			options["type"] = sinkType
+		}
+
+		sink, err := openSink(options)
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		slog.Debug("connecting to DB")
+		db, err := connect("source")
+
+		if err != nil {
+			slog.Error("couldn't connect to DB", "err", err)
 			return nil
 		}
 
-		fmt.Print("Successfully connected to DB\n")
+		slog.Debug("connected to DB")
+
+		recorder, closeHistory, err := historyRecorder(db, runSource)
+		if err != nil {
+			slog.Error("couldn't set up the run history", "err", err)
+			return nil
+		}
+		defer closeHistory()
+
+		if recorder != nil {
+			observer = archiver.Observers(observer, recorder)
+		}
 
-		if code != "" {
-			tables, err := parseCode(code)
+		runArchiver := archiver.New(db)
+		runArchiver.Observer = observer
+		runArchiver.Sink = sink
+
+		if jobConfig != nil {
+			runArchiver.Logger = slog.Default().With("job", metricsJob)
+
+			_, err = runArchiver.Archive(jobConfig, ctx)
+			finishProgress(runProgress)
+			writeMetrics(runMetrics, metricsTextfile, metricsJob, err)
+		} else if code != "" {
+			var tables []archiver.Table
+			tables, err = parseCode(code)
 			if err != nil {
-				fmt.Printf("Error parsing code: %+v", err)
+				slog.Error("couldn't parse the code", "err", err)
 				return nil
 			}
 
-			archiveConfig := database.NewArchiveManyConfig()
-			archiveConfig.DB = db
+			archiveConfig := archiver.NewConfig()
 			archiveConfig.Limit = limit
 			archiveConfig.OutputDir = viper.GetString("outputDir")
-			archiveConfig.CutoffDate = cutoff
+			archiveConfig.CutoffDate = cutoffDate
 			archiveConfig.Purge = purge
+			archiveConfig.Where = where
 
 			archiveConfig.Tables = tables
+			archiveConfig.Batches = batches
+			archiveConfig.LockTimeout = lockTimeout
 
-			err = database.ArchiveMany(archiveConfig)
+			_, err = runArchiver.Archive(archiveConfig, ctx)
+			finishProgress(runProgress)
+			writeMetrics(runMetrics, metricsTextfile, metricsJob, err)
 		} else {
 			if err := helpers.AssertError(table != "", "--table must be present"); err != nil {
 				return err
 			}
 
-			archiveConfig := database.NewArchiveConfig()
-			archiveConfig.DB = db
+			archiveConfig := archiver.NewConfig()
 			archiveConfig.Limit = limit
 			archiveConfig.OutputDir = viper.GetString("outputDir")
-			archiveConfig.CutoffDate = cutoff
+			archiveConfig.CutoffDate = cutoffDate
 			archiveConfig.Purge = purge
+			archiveConfig.Where = where
+
+			columns, err := cmd.Flags().GetStringSlice("columns")
+			if err != nil {
+				return err
+			}
+
+			exclude, err := cmd.Flags().GetStringSlice("exclude")
+			if err != nil {
+				return err
+			}
 
-			archiveConfig.Table = database.Table{
+			mask, err := cmd.Flags().GetStringToString("mask")
+			if err != nil {
+				return err
+			}
+
+			transforms, err := job.ParseMask(mask, os.Getenv("ARCHI_MASK_SALT"))
+			if err != nil {
+				return err
+			}
+
+			archiveConfig.Tables = []archiver.Table{{
 				Name:            table,
 				TimestampCol:    timestampCol,
 				RefTable:        relatedTable,
 				RefColumn:       relatedKey,
 				RefTimestampCol: relatedTimestampCol,
-			}
+				Columns:         columns,
+				Exclude:         exclude,
+				Transforms:      transforms,
+			}}
 
-			err = database.Archive(archiveConfig)
+			archiveConfig.Batches = batches
+			archiveConfig.LockTimeout = lockTimeout
+
+			_, err = runArchiver.Archive(archiveConfig, ctx)
+			finishProgress(runProgress)
+			writeMetrics(runMetrics, metricsTextfile, metricsJob, err)
 
 			if err != nil {
-				fmt.Printf("%+v", err)
+				slog.Error("archive failed", "err", err)
 				return nil
 			}
 
@@ -165,20 +383,12 @@ var veCmd = &cobra.Command{
 			}
 
 			if answer == "y" || answer == "Y" {
-				code := ""
-
-				if archiveConfig.Table.RefTable == "" {
-					code = fmt.Sprintf("m:%s:%s;", archiveConfig.Table.Name, archiveConfig.Table.TimestampCol)
-				} else {
-					code = fmt.Sprintf("r:%s:%s:%s:%s;", archiveConfig.Table.Name, archiveConfig.Table.RefTable, archiveConfig.Table.RefColumn, archiveConfig.Table.RefTimestampCol)
-				}
-
-				fmt.Printf("Code: %s\n", code)
+				fmt.Printf("Code: %s\n", formatCode(archiveConfig.Tables))
 			}
 		}
 
 		if err != nil {
-			fmt.Printf("%+v", err)
+			slog.Error("archive failed", "err", err)
 			return nil
 		}
 
@@ -188,33 +398,70 @@ var veCmd = &cobra.Command{
 
 func init() {
 	veCmd.SetUsageTemplate(`Usage:
-      ve --table=table_name --timestamp-col=requestTime [--cutoff=2025-06-06 --limit=100 --purge]
-      ve --table=table_name --related-table='related_table' --related-key='related_key' --related-timestamp-col='timestamp_col' [--cutoff=2025-06-06 --limit=100 --purge]
-      ve --code=m:table_name:timestamp_col [--cutoff=2025-06-06 --limit=100 --purge]
-      ve --code=r:table_name:relate_table:related_key:related_timestamp_col [--cutoff=2025-06-06 --limit=100 --purge]
-      ve --code=m:table_name:timestamp_col;r:table_name:relate_table:related_key:related_timestamp_col [--cutoff=2025-06-06 --limit=100 --purge]
+      ve --table=table_name --timestamp-col=requestTime (--cutoff=2025-06-06 | --older-than=90d) [--limit=100 --purge]
+      ve --table=table_name --related-table='related_table' --related-key='related_key' --related-timestamp-col='timestamp_col' (--cutoff=2025-06-06 | --older-than=90d) [--limit=100 --purge]
+      ve --code=m:table_name:timestamp_col (--cutoff=2025-06-06 | --older-than=90d) [--limit=100 --purge]
+      ve --code=r:table_name:relate_table:related_key:related_timestamp_col (--cutoff=2025-06-06 | --older-than=90d) [--limit=100 --purge]
+      ve --code=m:table_name:timestamp_col;r:table_name:relate_table:related_key:related_timestamp_col (--cutoff=2025-06-06 | --older-than=90d) [--limit=100 --purge]
+      ve --job=nightly.yaml [--cutoff=2025-06-06 | --older-than=90d] [--limit=100 --purge]
+      ve --interactive [--cutoff=2025-06-06 | --older-than=90d]
 
 Flags:
       -p, --purge                 delete rows from the table(s) (default: false)
-          --limit                 how many rows to archive from the table(s) (default: 100)
-          --cutoff                cutoff timestamp (default: now)
+          --limit                 how many rows to archive from the table(s) per batch (default: 100)
+          --batches               how many batches to archive, 0 archives until no rows are left (default: 1, or the job's batches with --job)
+          --cutoff                cutoff timestamp or expression: 2025-06-06, now-30d, today-1mo
+          --older-than            archive rows older than the age: 90d, 6mo, 1y, 1y6mo
+          --timezone              timezone for --cutoff and relative cutoffs (default: UTC)
+          --lock-timeout          how long to wait for tables another run is archiving: 30s, 5m;
+                                  0 fails right away, -1s waits without a limit (default: 0)
+          --where                 extra condition for the archived rows: "status IN ('closed','cancelled')"
+          --columns               columns to write to the archive (with --table)
+          --exclude               columns to leave out of the archive (with --table)
+          --mask                  column transforms: email=hash,name=truncate:1,ip=null,note=const:X (with --table)
+                                  hash takes its salt from ARCHI_MASK_SALT
           --table                 table to archive
           --timestamp-col         timestamp column of the table
           --related-table         name of the dependant table
           --related-key           foreign key of the dependant table
           --related-timestamp-col related timestamp column of the dependant table
           --code                  short format for appending with other codes
+          --job                   YAML or JSON job file with the tables to archive
+      -i, --interactive           pick the tables from the source database, preview their old rows
+                                  and print the code or write a job file for them
+          --sink                  where to write the archive: csv, jsonl, database or s3
+                                  (default: the sink.type config key, or csv)
+          --metrics-textfile      write Prometheus metrics of the run to this node_exporter textfile
+          --no-progress           don't show the progress, which is drawn on terminals and logged every 10s otherwise
       -h, --help                  show this message
+
+Global Flags:
+          --defaults-file         read MySQL credentials only from this option file
+          --log-level             debug, info, warn or error; debug logs the queries (default: info)
+          --log-format            text or json (default: text)
 `)
 	veCmd.Flags().String("table", "", "table to archive")
 	veCmd.Flags().BoolP("purge", "p", false, "delete rows from the table")
-	veCmd.Flags().Int32("limit", 100, "how many rows to archive")
+	veCmd.Flags().Int32("limit", 100, "how many rows to archive per batch")
+	veCmd.Flags().Int("batches", 1, "how many batches to archive, 0 for all")
 	veCmd.Flags().String("timestamp-col", "", "timestamp column")
-	veCmd.Flags().Time("cutoff", time.Now(), layouts, "cutoff timestamp")
+	veCmd.Flags().String("cutoff", "", "cutoff timestamp or expression like now-30d")
+	veCmd.Flags().String("older-than", "", "archive rows older than 90d, 6mo, 1y, ...")
+	veCmd.Flags().String("timezone", "UTC", "timezone to read --cutoff and compute relative cutoffs in")
+	veCmd.Flags().Duration("lock-timeout", 0, "how long to wait for tables another run is archiving")
 	veCmd.Flags().String("related-table", "", "name of the dependant table")
 	veCmd.Flags().String("related-key", "", "related key of the dependant table")
 	veCmd.Flags().String("related-timestamp-col", "", "related timestamp column of the dependant table")
 	veCmd.Flags().String("code", "", "short format for multiple tables")
+	veCmd.Flags().String("job", "", "job file with the tables to archive")
+	veCmd.Flags().BoolP("interactive", "i", false, "pick the tables to archive and print the code or write a job file")
+	veCmd.Flags().String("where", "", "extra condition for the archived rows")
+	veCmd.Flags().StringSlice("columns", nil, "columns to write to the archive")
+	veCmd.Flags().StringSlice("exclude", nil, "columns to leave out of the archive")
+	veCmd.Flags().StringToString("mask", nil, "column transforms")
+	veCmd.Flags().String("sink", "", "where to write the archive")
+	veCmd.Flags().String("metrics-textfile", "", "node_exporter textfile to write the metrics of the run to")
+	veCmd.Flags().Bool("no-progress", false, "don't show the progress of the run")
 
 	veCmd.MarkFlagsRequiredTogether("related-key", "related-table", "related-timestamp-col")
 
@@ -226,17 +473,30 @@ Flags:
 	veCmd.MarkFlagsMutuallyExclusive("related-table", "code")
 	veCmd.MarkFlagsMutuallyExclusive("related-key", "code")
 	veCmd.MarkFlagsMutuallyExclusive("related-timestamp-col", "code")
+	veCmd.MarkFlagsMutuallyExclusive("job", "code")
+	veCmd.MarkFlagsMutuallyExclusive("job", "table")
+	veCmd.MarkFlagsMutuallyExclusive("cutoff", "older-than")
+	veCmd.MarkFlagsMutuallyExclusive("columns", "exclude")
+
+	for _, flag := range []string{"table", "code", "job", "purge"} {
+		veCmd.MarkFlagsMutuallyExclusive(flag, "interactive")
+	}
+
+	for _, flag := range []string{"columns", "exclude", "mask"} {
+		veCmd.MarkFlagsMutuallyExclusive(flag, "code")
+		veCmd.MarkFlagsMutuallyExclusive(flag, "job")
+	}
 
 	rootCmd.AddCommand(veCmd)
 }
 
-func parseCode(code string) ([]database.Table, error) {
+func parseCode(code string) ([]archiver.Table, error) {
 	tableSplits := strings.Split(code, ";")
 	if len(tableSplits) == 0 {
 		return nil, fmt.Errorf("no tables found")
 	}
 
-	var tables []database.Table
+	var tables []archiver.Table
 
 	for _, t := range tableSplits {
 		if t == "" {
@@ -261,7 +521,7 @@ func parseCode(code string) ([]database.Table, error) {
 			table := paramsSplits[1]
 			timestampCol := paramsSplits[2]
 
-			tables = append(tables, database.Table{
+			tables = append(tables, archiver.Table{
 				Name:         table,
 				TimestampCol: timestampCol,
 			})
@@ -275,7 +535,7 @@ func parseCode(code string) ([]database.Table, error) {
 			refCol := paramsSplits[3]
 			refTimestampCol := paramsSplits[4]
 
-			tables = append(tables, database.Table{
+			tables = append(tables, archiver.Table{
 				Name:            table,
 				RefTable:        refTable,
 				RefColumn:       refCol,
@@ -286,3 +546,23 @@ func parseCode(code string) ([]database.Table, error) {
 
 	return tables, nil
 }
+
+// writeMetrics records the outcome of the run and writes the metrics to the
+// textfile. Failing to write them doesn't fail the run.
+func writeMetrics(runMetrics *metrics.Metrics, path string, job string, err error) {
+	if runMetrics == nil {
+		return
+	}
+
+	runMetrics.Finished(job, err)
+
+	if err := runMetrics.WriteTextfile(path); err != nil {
+		slog.Error("couldn't write metrics", "file", path, "err", err)
+	}
+}
+
+func finishProgress(runProgress *progress.Progress) {
+	if runProgress != nil {
+		runProgress.Finish()
+	}
+}
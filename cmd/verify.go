@@ -0,0 +1,214 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// verifyListedIDs is how many ids of a kind verify prints
+const verifyListedIDs = 20
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <archive or catalog>...",
+	Short: "Reconcile archive files with the source and destination databases",
+	Long: `
+Read CSV or JSONL archives and look their rows up by id:
+
+  - rows still in the source after a run that purged them were missed by the
+    purge, unless they are the rows the purge kept since they changed after
+    they were archived
+  - rows in neither the source nor the destination are lost
+  - rows in the destination that differ from the archived ones are mismatched
+
+Whether the run of an archive purged its rows is read from the catalog of its
+directory. Archives missing from the catalog are taken as not purged, unless
+--purged is set.
+
+The table is read from the name of the archive unless --table is set. The
+destination is compared with when the sink type is database, in the table with
+the sink prefix, or when --dest is set:
+
+  archi verify /var/archives/archived_orders_till_*.csv
+  archi verify --table=orders --dest=false orders.jsonl
+
+Given the catalog of a directory, archi-catalog.json, every archive in it is
+verified. Archives in the catalog of their directory are also checked against
+the checksum recorded there:
+
+  archi verify /var/archives/archi-catalog.json
+
+verify fails when any archive doesn't reconcile, so it can run from cron.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("%+v\n\n%s", err, "To create config file:\n  archi config")
+		}
+
+		table, err := cmd.Flags().GetString("table")
+		if err != nil {
+			return err
+		}
+
+		purged, err := cmd.Flags().GetBool("purged")
+		if err != nil {
+			return err
+		}
+
+		options := sinkOptions(nil)
+
+		compare := strings.EqualFold(options["type"], "database")
+		if cmd.Flags().Changed("dest") {
+			if compare, err = cmd.Flags().GetBool("dest"); err != nil {
+				return err
+			}
+		}
+
+		source, err := connect("source")
+		if err != nil {
+			return fmt.Errorf("error connecting to DB: %+v", err)
+		}
+		defer source.Close()
+
+		var destination *sql.DB
+		if compare {
+			if destination, err = connect("destination"); err != nil {
+				return fmt.Errorf("error connecting to the destination DB: %+v", err)
+			}
+			defer destination.Close()
+		}
+
+		paths, err := verifyPaths(args)
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+
+		for _, path := range paths {
+			name := table
+			if name == "" {
+				parsed, ok := archiver.ParseArchiveName(path)
+				if !ok {
+					fmt.Printf("%s: can't tell the table from the name, set --table\n", path)
+					failed++
+					continue
+				}
+
+				name = parsed.Name
+			}
+
+			result, err := archiver.VerifyArchive(path, name, source, destination, options["prefix"]+name, purged)
+			if err != nil {
+				fmt.Printf("%s: %v\n", path, err)
+				failed++
+				continue
+			}
+
+			printVerification(os.Stdout, result)
+
+			if !result.OK() {
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d archives failed verification", failed, len(paths))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	verifyCmd.Flags().String("table", "", "source table of the archives (default: read from their names)")
+	verifyCmd.Flags().Bool("dest", false, "compare with the destination database (default: when the sink type is database)")
+	verifyCmd.Flags().Bool("purged", false, "fail on rows still in the source for archives missing from the catalog")
+
+	rootCmd.AddCommand(verifyCmd)
+}
+
+// verifyPaths replaces the catalogs among the arguments with the archives in
+// them
+func verifyPaths(args []string) ([]string, error) {
+	var paths []string
+
+	for _, arg := range args {
+		if filepath.Base(arg) != archiver.CatalogFile {
+			paths = append(paths, arg)
+			continue
+		}
+
+		dir := filepath.Dir(arg)
+
+		catalog, err := archiver.LoadCatalog(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range catalog.Archives {
+			paths = append(paths, filepath.Join(dir, entry.File))
+		}
+	}
+
+	return paths, nil
+}
+
+func printVerification(out io.Writer, result *archiver.Verification) {
+	status := "ok"
+	if !result.OK() {
+		status = "FAILED"
+	}
+
+	fmt.Fprintf(out, "%s: %s, %d rows of %s\n", result.Path, status, result.Rows, result.Table)
+
+	if result.ChecksumChanged {
+		fmt.Fprintln(out, "  file changed since it was added to the catalog")
+	}
+
+	switch {
+	case len(result.InSource) == 0:
+	case result.MissedPurge():
+		printVerifiedIDs(out, fmt.Sprintf("still in the source, the purge kept %d", result.Kept), result.InSource)
+	case result.Purged:
+		printVerifiedIDs(out, "still in the source, kept by the purge since they changed after they were archived", result.InSource)
+	default:
+		printVerifiedIDs(out, "still in the source, the run didn't purge", result.InSource)
+	}
+
+	if !result.Destination {
+		fmt.Fprintln(out, "  destination not compared")
+		return
+	}
+
+	printVerifiedIDs(out, "in neither the source nor the destination", result.Lost)
+	printVerifiedIDs(out, "different in the destination", result.Mismatched)
+}
+
+func printVerifiedIDs(out io.Writer, what string, ids []uint64) {
+	if len(ids) == 0 {
+		return
+	}
+
+	listed := make([]string, 0, verifyListedIDs)
+	for _, id := range ids[:min(len(ids), verifyListedIDs)] {
+		listed = append(listed, fmt.Sprint(id))
+	}
+
+	more := ""
+	if len(ids) > verifyListedIDs {
+		more = fmt.Sprintf(" and %d more", len(ids)-verifyListedIDs)
+	}
+
+	fmt.Fprintf(out, "  %d %s: %s%s\n", len(ids), what, strings.Join(listed, ", "), more)
+}
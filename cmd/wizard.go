@@ -0,0 +1,455 @@
+/*
+Copyright © 2025 fn3x <fn3x@proton.me>
+*/
+package cmd
+
+import (
+	"bufio"
+	"cmp"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/fn3x/archivator/internal/cutoff"
+	database "github.com/fn3x/archivator/internal/db"
+	"github.com/fn3x/archivator/internal/history"
+	"github.com/fn3x/archivator/internal/job"
+	"github.com/spf13/cobra"
+)
+
+// wizardTable is a table of the source database as the wizard offers it
+type wizardTable struct {
+	name       string
+	rows       int64
+	timestamps []string
+	// parents are the foreign keys of the table, children the ones
+	// referencing it
+	parents  []database.ForeignKey
+	children []database.ForeignKey
+}
+
+// runWizard lets the user pick the tables to archive and how to find their
+// old rows, previews how many rows are older than the cutoff and prints the
+// code or writes a job file for them
+func runWizard(cmd *cobra.Command) error {
+	scanner := bufio.NewScanner(os.Stdin)
+
+	cutoffExpr, err := cmd.Flags().GetString("cutoff")
+	if err != nil {
+		return err
+	}
+
+	olderThan, err := cmd.Flags().GetString("older-than")
+	if err != nil {
+		return err
+	}
+
+	timezone, err := cmd.Flags().GetString("timezone")
+	if err != nil {
+		return err
+	}
+
+	limit, err := cmd.Flags().GetInt32("limit")
+	if err != nil {
+		return err
+	}
+
+	if cutoffExpr == "" && olderThan == "" {
+		if olderThan, err = ask(scanner, "Archive rows older than (90d): ", "90d"); err != nil {
+			return err
+		}
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return fmt.Errorf("unknown timezone %q: %v", timezone, err)
+	}
+
+	cutoffDate, err := cutoff.Resolve(cutoffExpr, olderThan, time.Now(), loc)
+	if err != nil {
+		return err
+	}
+
+	db, err := connect("source")
+	if err != nil {
+		return fmt.Errorf("error connecting to DB: %+v", err)
+	}
+	defer db.Close()
+
+	tables, err := wizardTables(db)
+	if err != nil {
+		return err
+	}
+
+	if len(tables) == 0 {
+		return fmt.Errorf("the source database has no tables")
+	}
+
+	printWizardTables(os.Stdout, tables)
+
+	picked, err := pickTables(scanner, tables)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]*wizardTable, len(tables))
+	for i := range tables {
+		byName[tables[i].name] = &tables[i]
+	}
+
+	var chosen []archiver.Table
+	for _, table := range picked {
+		choice, ok, err := chooseArchiveBy(scanner, table, byName)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			chosen = append(chosen, choice)
+		}
+	}
+
+	chosen, err = suggestChildren(scanner, chosen, byName)
+	if err != nil {
+		return err
+	}
+
+	if len(chosen) == 0 {
+		return fmt.Errorf("no tables to archive")
+	}
+
+	// children go first so a purge deletes them before the rows they
+	// reference
+	slices.SortStableFunc(chosen, func(a archiver.Table, b archiver.Table) int {
+		return cmp.Compare(archivedByRef(b), archivedByRef(a))
+	})
+
+	if err := previewTables(os.Stdout, db, chosen, cutoffDate); err != nil {
+		return err
+	}
+
+	answer, err := ask(scanner, "\nPrint the (c)ode or write a (j)ob file? [c]: ", "c")
+	if err != nil {
+		return err
+	}
+
+	age := "--older-than=" + olderThan
+	if cutoffExpr != "" {
+		age = "--cutoff=" + cutoffExpr
+	}
+
+	if !strings.EqualFold(answer, "j") {
+		code := formatCode(chosen)
+		fmt.Printf("Code: %s\n\nRun it with:\n  archi ve --code='%s' %s\n", code, code, age)
+
+		return nil
+	}
+
+	path, err := ask(scanner, "Job file (archive.yaml): ", "archive.yaml")
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		overwrite, err := ask(scanner, fmt.Sprintf("%s exists, overwrite it? (y/n) [n]: ", path), "n")
+		if err != nil {
+			return err
+		}
+
+		if !strings.EqualFold(overwrite, "y") {
+			return nil
+		}
+	}
+
+	archiveJob := &job.Job{
+		Name:      strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Cutoff:    cutoffExpr,
+		OlderThan: olderThan,
+		Limit:     limit,
+	}
+
+	if cmd.Flags().Changed("timezone") {
+		archiveJob.Timezone = timezone
+	}
+
+	for _, table := range chosen {
+		jobTable := job.Table{Name: table.Name, TimestampCol: table.TimestampCol}
+		if table.TimestampCol == "" {
+			jobTable.Related = &job.Related{Table: table.RefTable, Key: table.RefColumn, TimestampCol: table.RefTimestampCol}
+		}
+
+		archiveJob.Tables = append(archiveJob.Tables, jobTable)
+	}
+
+	if err := archiveJob.Validate(); err != nil {
+		return err
+	}
+
+	if err := archiveJob.Save(path); err != nil {
+		return fmt.Errorf("couldn't write %s: %v", path, err)
+	}
+
+	fmt.Printf("Wrote %s, run it with:\n  archi ve --job=%s\n", path, path)
+
+	return nil
+}
+
+// wizardTables reads the tables of the source database with their sizes,
+// timestamp columns and foreign keys, leaving out the run history
+func wizardTables(db *sql.DB) ([]wizardTable, error) {
+	sizes, err := database.TableSizes(db)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the tables: %v", err)
+	}
+
+	columns, err := database.TimestampColumns(db)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the columns: %v", err)
+	}
+
+	keys, err := database.ForeignKeys(db)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the foreign keys: %v", err)
+	}
+
+	var tables []wizardTable
+	for _, size := range sizes {
+		if size.Name == history.Table {
+			continue
+		}
+
+		table := wizardTable{name: size.Name, rows: size.Rows}
+
+		for _, column := range columns {
+			if column.Table == size.Name {
+				table.timestamps = append(table.timestamps, column.Column)
+			}
+		}
+
+		for _, key := range keys {
+			if key.Table == size.Name {
+				table.parents = append(table.parents, key)
+			}
+
+			if key.RefTable == size.Name {
+				table.children = append(table.children, key)
+			}
+		}
+
+		tables = append(tables, table)
+	}
+
+	return tables, nil
+}
+
+func printWizardTables(out io.Writer, tables []wizardTable) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\t TABLE\tROWS (EST.)\tTIMESTAMP COLUMNS\tREFERENCES")
+
+	for i, table := range tables {
+		var references []string
+		for _, key := range table.parents {
+			references = append(references, fmt.Sprintf("%s (%s)", key.RefTable, key.Column))
+		}
+
+		fmt.Fprintf(w, "%d\t %s\t%d\t%s\t%s\n", i+1, table.name, table.rows, orDash(strings.Join(table.timestamps, ", ")), orDash(strings.Join(references, ", ")))
+	}
+
+	w.Flush()
+}
+
+// pickTables asks for the tables to archive by number or name until every
+// answer names a table
+func pickTables(scanner *bufio.Scanner, tables []wizardTable) ([]*wizardTable, error) {
+	for {
+		answer, err := ask(scanner, "\nTables to archive (numbers or names, comma separated): ", "")
+		if err != nil {
+			return nil, err
+		}
+
+		var picked []*wizardTable
+		var unknown []string
+
+		for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' }) {
+			i := slices.IndexFunc(tables, func(table wizardTable) bool { return table.name == field })
+			if n, err := strconv.Atoi(field); err == nil && n >= 1 && n <= len(tables) {
+				i = n - 1
+			}
+
+			if i < 0 {
+				unknown = append(unknown, field)
+				continue
+			}
+
+			if !slices.Contains(picked, &tables[i]) {
+				picked = append(picked, &tables[i])
+			}
+		}
+
+		if len(unknown) > 0 {
+			fmt.Printf("Unknown tables: %s\n", strings.Join(unknown, ", "))
+			continue
+		}
+
+		if len(picked) > 0 {
+			return picked, nil
+		}
+	}
+}
+
+// archiveByOptions are the ways to archive the table: by one of its own
+// timestamp columns, or by a timestamp column of a table it references by id
+func archiveByOptions(table *wizardTable, byName map[string]*wizardTable) []archiver.Table {
+	var options []archiver.Table
+
+	for _, column := range table.timestamps {
+		options = append(options, archiver.Table{Name: table.name, TimestampCol: column})
+	}
+
+	for _, key := range table.parents {
+		parent, ok := byName[key.RefTable]
+		if !ok || key.RefColumn != "id" {
+			continue
+		}
+
+		for _, column := range parent.timestamps {
+			options = append(options, archiver.Table{Name: table.name, RefTable: parent.name, RefColumn: key.Column, RefTimestampCol: column})
+		}
+	}
+
+	return options
+}
+
+// chooseArchiveBy asks how to archive the table. ok is false when there is
+// no way to or the user skips it.
+func chooseArchiveBy(scanner *bufio.Scanner, table *wizardTable, byName map[string]*wizardTable) (archiver.Table, bool, error) {
+	options := archiveByOptions(table, byName)
+	if len(options) == 0 {
+		fmt.Printf("\n%s has no date or time column and references no table with one by id, skipping it\n", table.name)
+		return archiver.Table{}, false, nil
+	}
+
+	fmt.Printf("\nArchive %s by:\n", table.name)
+	for i, option := range options {
+		fmt.Printf("  %d) %s\n", i+1, describeArchiveBy(option))
+	}
+
+	for {
+		answer, err := ask(scanner, "Choice, or (s)kip [1]: ", "1")
+		if err != nil {
+			return archiver.Table{}, false, err
+		}
+
+		if strings.EqualFold(answer, "s") {
+			return archiver.Table{}, false, nil
+		}
+
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return options[n-1], true, nil
+		}
+	}
+}
+
+// suggestChildren offers to archive the tables referencing a table archived
+// by its own timestamp column along with it, by that column
+func suggestChildren(scanner *bufio.Scanner, chosen []archiver.Table, byName map[string]*wizardTable) ([]archiver.Table, error) {
+	parents := slices.Clone(chosen)
+
+	for _, parent := range parents {
+		if parent.TimestampCol == "" {
+			continue
+		}
+
+		for _, key := range byName[parent.Name].children {
+			if key.RefColumn != "id" || slices.ContainsFunc(chosen, func(t archiver.Table) bool { return t.Name == key.Table }) {
+				continue
+			}
+
+			answer, err := ask(scanner, fmt.Sprintf("\n%s references %s through %s. Archive it along? (y/n) [y]: ", key.Table, parent.Name, key.Column), "y")
+			if err != nil {
+				return nil, err
+			}
+
+			if strings.EqualFold(answer, "y") {
+				chosen = append(chosen, archiver.Table{Name: key.Table, RefTable: parent.Name, RefColumn: key.Column, RefTimestampCol: parent.TimestampCol})
+			}
+		}
+	}
+
+	return chosen, nil
+}
+
+// previewTables prints how many rows of every table are older than the cutoff
+func previewTables(out io.Writer, db *sql.DB, tables []archiver.Table, cutoffDate time.Time) error {
+	fmt.Fprintf(out, "\nRows older than %s:\n\n", cutoffDate.Format(time.RFC3339))
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tARCHIVED BY\tROWS")
+
+	for _, table := range tables {
+		table.CutoffDate = cutoffDate
+
+		count, err := database.CountRows(slog.Default(), db, table)
+		if err != nil {
+			return fmt.Errorf("couldn't count the rows of %s: %v", table.Name, err)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%d\n", table.Name, describeArchiveBy(table), count)
+	}
+
+	return w.Flush()
+}
+
+// archivedByRef is 1 for a table archived by the timestamp of the table it
+// references
+func archivedByRef(table archiver.Table) int {
+	if table.TimestampCol == "" {
+		return 1
+	}
+
+	return 0
+}
+
+func describeArchiveBy(table archiver.Table) string {
+	if table.TimestampCol != "" {
+		return table.TimestampCol
+	}
+
+	return fmt.Sprintf("%s.%s through %s", table.RefTable, table.RefTimestampCol, table.RefColumn)
+}
+
+// ask prints the prompt and reads an answer, def when it is empty
+func ask(scanner *bufio.Scanner, prompt string, def string) (string, error) {
+	fmt.Print(prompt)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+
+		return "", io.ErrUnexpectedEOF
+	}
+
+	if answer := strings.TrimSpace(scanner.Text()); answer != "" {
+		return answer, nil
+	}
+
+	return def, nil
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
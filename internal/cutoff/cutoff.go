@@ -0,0 +1,203 @@
+// Package cutoff turns absolute timestamps, "now-30d" style expressions and
+// ages like "90d" into cutoff dates.
+package cutoff
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var Layouts = []string{
+	time.RFC3339,
+	time.RFC3339Nano,
+	time.RFC822,
+	time.RFC822Z,
+	time.RFC850,
+	time.RFC1123,
+	time.RFC1123Z,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"01/02/2006",
+	"01-02-2006",
+	"2006/01/02",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"2006-01-02 15:04:05.000000",
+}
+
+// Age is a calendar-aware duration such as 1y6mo or 90d. Years, months and
+// days follow the calendar of the location, so 1mo before March 31 is the
+// last day of February. The rest is a plain duration.
+type Age struct {
+	Years    int
+	Months   int
+	Days     int
+	Duration time.Duration
+}
+
+// Before returns the moment that is the age before t
+func (a Age) Before(t time.Time) time.Time {
+	return addMonths(t, -12*a.Years-a.Months).AddDate(0, 0, -a.Days).Add(-a.Duration)
+}
+
+func (a Age) after(t time.Time) time.Time {
+	return addMonths(t, 12*a.Years+a.Months).AddDate(0, 0, a.Days).Add(a.Duration)
+}
+
+// addMonths moves t by n months, clamping the day to the end of the target
+// month instead of overflowing into the next one like time.AddDate does
+func addMonths(t time.Time, n int) time.Time {
+	if n == 0 {
+		return t
+	}
+
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location()).AddDate(0, n, 0)
+	lastDay := first.AddDate(0, 1, -1).Day()
+
+	return first.AddDate(0, 0, min(t.Day(), lastDay)-1)
+}
+
+// ParseAge parses one or more number and unit pairs, e.g. 90d, 6mo, 1y or
+// 1y6mo. Units are s, m, h, d, w, mo and y. An age of 0 is an error, since it
+// would archive every row.
+func ParseAge(value string) (Age, error) {
+	var age Age
+
+	rest := strings.TrimSpace(value)
+	if rest == "" {
+		return age, fmt.Errorf("empty age")
+	}
+
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+
+		if i == 0 {
+			return age, fmt.Errorf("couldn't parse age %q: expected a number at %q", value, rest)
+		}
+
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return age, fmt.Errorf("couldn't parse age %q: %v", value, err)
+		}
+
+		rest = rest[i:]
+
+		j := 0
+		for j < len(rest) && (rest[j] < '0' || rest[j] > '9') {
+			j++
+		}
+
+		unit := strings.ToLower(rest[:j])
+		rest = rest[j:]
+
+		switch unit {
+		case "s", "m", "h":
+			d := durationUnits[unit]
+			if time.Duration(n) > (math.MaxInt64-age.Duration)/d {
+				return age, fmt.Errorf("couldn't parse age %q: too long", value)
+			}
+
+			age.Duration += time.Duration(n) * d
+		case "d":
+			age.Days += n
+		case "w":
+			age.Days += 7 * n
+		case "mo":
+			age.Months += n
+		case "y":
+			age.Years += n
+		default:
+			return age, fmt.Errorf("couldn't parse age %q: unknown unit %q, expected one of s, m, h, d, w, mo, y", value, unit)
+		}
+	}
+
+	if age == (Age{}) {
+		return age, fmt.Errorf("couldn't parse age %q: the age must be longer than 0", value)
+	}
+
+	return age, nil
+}
+
+var durationUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+// OlderThan returns the cutoff for rows older than the age, counted back from now
+func OlderThan(value string, now time.Time) (time.Time, error) {
+	age, err := ParseAge(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return age.Before(now), nil
+}
+
+// Parse resolves value to a cutoff in loc. Value is either a timestamp in one
+// of the Layouts, or "now" or "today" (midnight) optionally followed by an
+// offset, e.g. now-30d or today-1mo.
+func Parse(value string, now time.Time, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	now = now.In(loc)
+
+	for _, anchor := range []string{"now", "today"} {
+		if !strings.HasPrefix(strings.ToLower(value), anchor) {
+			continue
+		}
+
+		base := now
+		if anchor == "today" {
+			base = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		}
+
+		offset := strings.TrimSpace(value[len(anchor):])
+		if offset == "" {
+			return base, nil
+		}
+
+		if offset[0] != '-' && offset[0] != '+' {
+			return time.Time{}, fmt.Errorf("couldn't parse %q: expected - or + after %s", value, anchor)
+		}
+
+		age, err := ParseAge(offset[1:])
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if offset[0] == '+' {
+			return age.after(base), nil
+		}
+
+		return age.Before(base), nil
+	}
+
+	for _, layout := range Layouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("couldn't parse %q as a timestamp or a now-30d style expression", value)
+}
+
+// Resolve returns the cutoff from either an expression for Parse or an age
+// for OlderThan. It is an error to give both or neither.
+func Resolve(expr string, olderThan string, now time.Time, loc *time.Location) (time.Time, error) {
+	if expr != "" && olderThan != "" {
+		return time.Time{}, fmt.Errorf("cutoff and older-than can't be used together")
+	}
+
+	if olderThan != "" {
+		return OlderThan(olderThan, now.In(loc))
+	}
+
+	if expr != "" {
+		return Parse(expr, now, loc)
+	}
+
+	return time.Time{}, fmt.Errorf("no cutoff given, use --cutoff or --older-than")
+}
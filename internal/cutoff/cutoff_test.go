@@ -0,0 +1,233 @@
+package cutoff
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func date(year int, month time.Month, day int, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+func TestAddMonths(t *testing.T) {
+	tests := []struct {
+		name string
+		t    time.Time
+		n    int
+		want time.Time
+	}{
+		{"Jan 31 + 1mo", date(2025, time.January, 31, 0), 1, date(2025, time.February, 28, 0)},
+		{"Jan 31 + 1mo in a leap year", date(2024, time.January, 31, 0), 1, date(2024, time.February, 29, 0)},
+		{"Mar 31 - 1mo", date(2025, time.March, 31, 0), -1, date(2025, time.February, 28, 0)},
+		{"May 31 - 1mo", date(2025, time.May, 31, 0), -1, date(2025, time.April, 30, 0)},
+		{"Jan 31 + 13mo", date(2025, time.January, 31, 0), 13, date(2026, time.February, 28, 0)},
+		{"Feb 29 - 12mo", date(2024, time.February, 29, 0), -12, date(2023, time.February, 28, 0)},
+		{"Dec 15 + 1mo", date(2025, time.December, 15, 0), 1, date(2026, time.January, 15, 0)},
+		{"Jan 15 - 1mo", date(2025, time.January, 15, 0), -1, date(2024, time.December, 15, 0)},
+		{"keeps the time of day", time.Date(2025, time.January, 31, 13, 45, 30, 5, time.UTC), 1, time.Date(2025, time.February, 28, 13, 45, 30, 5, time.UTC)},
+		{"0mo", date(2025, time.January, 31, 0), 0, date(2025, time.January, 31, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addMonths(tt.t, tt.n); !got.Equal(tt.want) {
+				t.Errorf("addMonths(%v, %d) = %v, want %v", tt.t, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Age
+	}{
+		{"30s", Age{Duration: 30 * time.Second}},
+		{"15m", Age{Duration: 15 * time.Minute}},
+		{"12h", Age{Duration: 12 * time.Hour}},
+		{"90d", Age{Days: 90}},
+		{"2w", Age{Days: 14}},
+		{"6mo", Age{Months: 6}},
+		{"1y", Age{Years: 1}},
+		{"1y6mo", Age{Years: 1, Months: 6}},
+		{"1w2d", Age{Days: 9}},
+		{"1d12h30m", Age{Days: 1, Duration: 12*time.Hour + 30*time.Minute}},
+		{"0y6mo", Age{Months: 6}},
+		{"1Y6MO", Age{Years: 1, Months: 6}},
+		{" 90d ", Age{Days: 90}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseAge(tt.value)
+			if err != nil {
+				t.Fatalf("ParseAge(%q): %v", tt.value, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseAge(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAgeRejects(t *testing.T) {
+	tests := []string{
+		"",
+		"0d",
+		"0s",
+		"0y0mo",
+		"-1d",
+		"-90d",
+		"d",
+		"90",
+		"90x",
+		"90 days",
+		"1.5d",
+		"1y-6mo",
+		"99999999999999999999d",
+		"9999999999999h",
+		"2562047h2562047h",
+	}
+
+	for _, value := range tests {
+		t.Run(value, func(t *testing.T) {
+			if age, err := ParseAge(value); err == nil {
+				t.Errorf("ParseAge(%q) = %+v, want an error", value, age)
+			}
+		})
+	}
+}
+
+func TestOlderThan(t *testing.T) {
+	now := date(2025, time.March, 31, 12)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"1mo", date(2025, time.February, 28, 12)},
+		{"1y1mo", date(2024, time.February, 29, 12)},
+		{"30d", date(2025, time.March, 1, 12)},
+		{"1w", date(2025, time.March, 24, 12)},
+		{"36h", date(2025, time.March, 30, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := OlderThan(tt.value, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !got.Equal(tt.want) {
+				t.Errorf("OlderThan(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	now := time.Date(2025, time.March, 31, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"now", now},
+		{"NOW", now},
+		{"today", date(2025, time.March, 31, 0)},
+		{"now-30d", time.Date(2025, time.March, 1, 15, 4, 5, 0, time.UTC)},
+		{"now+1h", time.Date(2025, time.March, 31, 16, 4, 5, 0, time.UTC)},
+		{"today-1mo", date(2025, time.February, 28, 0)},
+		{"today+1mo", date(2025, time.April, 30, 0)},
+		{"today - 1y", date(2024, time.March, 31, 0)},
+		{"2025-01-02", date(2025, time.January, 2, 0)},
+		{"2025-01-02 03:04:05", time.Date(2025, time.January, 2, 3, 4, 5, 0, time.UTC)},
+		{"2025-01-02T03:04:05+02:00", time.Date(2025, time.January, 2, 1, 4, 5, 0, time.UTC)},
+		{"01/02/2025", date(2025, time.January, 2, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := Parse(tt.value, now, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !got.Equal(tt.want) {
+				t.Errorf("Parse(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	now := date(2025, time.March, 31, 12)
+
+	for _, value := range []string{"", "yesterday", "now30d", "now*1d", "now-", "now-0d", "today-x", "2025-13-01"} {
+		t.Run(value, func(t *testing.T) {
+			if got, err := Parse(value, now, time.UTC); err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", value, got)
+			}
+		})
+	}
+}
+
+func TestResolveInTheLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// still March 30 in New York
+	now := date(2025, time.March, 31, 2)
+
+	tests := []struct {
+		name      string
+		expr      string
+		olderThan string
+		loc       *time.Location
+		want      time.Time
+	}{
+		{"older than in UTC", "", "1mo", time.UTC, date(2025, time.February, 28, 2)},
+		// Mar 30 22:00 EDT is Feb 28 22:00 EST a month earlier
+		{"older than in New York", "", "1mo", newYork, date(2025, time.March, 1, 3)},
+		{"today in UTC", "today", "", time.UTC, date(2025, time.March, 31, 0)},
+		{"today in New York", "today", "", newYork, date(2025, time.March, 30, 4)},
+		// days follow the calendar, across the switch to daylight saving time
+		{"today-30d in New York", "today-30d", "", newYork, date(2025, time.February, 28, 5)},
+		{"date in New York", "2025-01-01", "", newYork, date(2025, time.January, 1, 5)},
+		{"date with an offset", "2025-01-01T00:00:00Z", "", newYork, date(2025, time.January, 1, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(tt.expr, tt.olderThan, now, tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !got.Equal(tt.want) {
+				t.Errorf("Resolve(%q, %q) = %v, want %v", tt.expr, tt.olderThan, got.UTC(), tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveNeedsOneCutoff(t *testing.T) {
+	now := date(2025, time.March, 31, 12)
+
+	if _, err := Resolve("today", "30d", now, time.UTC); err == nil {
+		t.Error("Resolve with a cutoff and an age succeeded")
+	}
+
+	if _, err := Resolve("", "", now, time.UTC); err == nil {
+		t.Error("Resolve without a cutoff succeeded")
+	}
+
+	if _, err := Resolve("", "0d", now, time.UTC); err == nil {
+		t.Error("Resolve with an age of 0 succeeded")
+	}
+}
@@ -0,0 +1,460 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"modernc.org/sqlite"
+)
+
+const (
+	MySQL    = "mysql"
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// Engines lists the database engines archi can archive from
+var Engines = []string{MySQL, Postgres, SQLite}
+
+// Dialect is what differs between the database engines: placeholders,
+// identifier quoting, catalog queries and locks.
+type Dialect interface {
+	Name() string
+	// Builder returns a squirrel statement builder using the placeholders
+	// of the engine
+	Builder() sq.StatementBuilderType
+	QuoteIdent(name string) string
+	// Schema is the SQL expression of the schema tables are looked up in
+	Schema() string
+	// MaxParams is how many parameters a statement may have
+	MaxParams() int
+
+	// Upsert inserts rows, leaving or replacing the ones with a key that
+	// is already taken
+	Upsert(table string, columns []string) sq.InsertBuilder
+	// CreateTable returns the statement creating the archive table with the
+	// columns when it is missing, empty when the engine needs the tables
+	// to be created beforehand
+	CreateTable(table string, columns []string) string
+
+	// tablesQuery selects the tables of the schema
+	tablesQuery() string
+	// columnsQuery selects the columns of the table given as the only
+	// parameter in their ordinal order
+	columnsQuery() string
+	// primaryKeyQuery selects the primary key columns of the table given
+	// as the only parameter
+	primaryKeyQuery() string
+	// foreignKeysQuery selects the table, column, referenced table and
+	// referenced column of the foreign keys of the schema
+	foreignKeysQuery() string
+	// tableSizesQuery selects the table, estimated rows, -1 when the engine
+	// keeps no estimate, and size in bytes of the tables of the schema
+	tableSizesQuery() string
+	// indexedColumnsQuery selects the table and column of the first
+	// column of every index of the schema
+	indexedColumnsQuery() string
+	// timestampColumnsQuery selects the table, column and type of the date
+	// and time columns of the schema
+	timestampColumnsQuery() string
+	// timeValue is the parameter a timestamp column is compared with
+	timeValue(t time.Time) any
+
+	lockName(database string, table string) string
+	// lock takes the named lock on conn, waiting up to seconds for it,
+	// without a limit when seconds is negative
+	lock(ctx context.Context, conn *sql.Conn, name string, seconds int) (bool, error)
+	unlock(ctx context.Context, conn *sql.Conn, name string)
+	// lockHolder describes the connection or process holding the lock
+	lockHolder(ctx context.Context, db *sql.DB, name string) (string, bool)
+}
+
+// DialectOf returns the dialect of the driver db was opened with, MySQL
+// unless it is a PostgreSQL or SQLite driver
+func DialectOf(db *sql.DB) Dialect {
+	switch db.Driver().(type) {
+	case *stdlib.Driver:
+		return postgresDialect{}
+	case *sqlite.Driver:
+		return sqliteDialect{}
+	}
+
+	return mysqlDialect{}
+}
+
+// LookupDialect returns the dialect of the engine, MySQL when engine is empty
+func LookupDialect(engine string) (Dialect, error) {
+	switch strings.ToLower(engine) {
+	case "", MySQL:
+		return mysqlDialect{}, nil
+	case Postgres, "postgresql":
+		return postgresDialect{}, nil
+	case SQLite, "sqlite3":
+		return sqliteDialect{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown engine %q, expected one of: %s", engine, strings.Join(Engines, ", "))
+}
+
+// Connect opens and pings a connection to the engine with the DSN: a
+// go-sql-driver DSN for MySQL, a connection string or URL for PostgreSQL,
+// the database file for SQLite
+func Connect(engine string, dsn string, ctx context.Context) (*sql.DB, error) {
+	dialect, err := LookupDialect(engine)
+	if err != nil {
+		return nil, err
+	}
+
+	switch dialect.Name() {
+	case Postgres:
+		return ConnectPostgres(dsn, ctx)
+	case SQLite:
+		return ConnectSQLite(dsn, ctx)
+	}
+
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dsn: %v", err)
+	}
+
+	return ConnectDB(config, ctx)
+}
+
+// DatabaseName returns the name of the database in the DSN of the engine,
+// the file for SQLite
+func DatabaseName(engine string, dsn string) string {
+	dialect, err := LookupDialect(engine)
+	if err != nil {
+		return ""
+	}
+
+	switch dialect.Name() {
+	case Postgres:
+		if config, err := pgx.ParseConfig(dsn); err == nil {
+			return config.Database
+		}
+
+		return ""
+	case SQLite:
+		return sqliteFile(dsn)
+	}
+
+	if config, err := mysql.ParseDSN(dsn); err == nil {
+		return config.DBName
+	}
+
+	return ""
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string {
+	return MySQL
+}
+
+func (mysqlDialect) Builder() sq.StatementBuilderType {
+	return sq.StatementBuilder.PlaceholderFormat(sq.Question)
+}
+
+func (mysqlDialect) QuoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (mysqlDialect) Schema() string {
+	return "DATABASE()"
+}
+
+func (mysqlDialect) MaxParams() int {
+	return 65535
+}
+
+func (mysqlDialect) foreignKeysQuery() string {
+	return `SELECT TABLE_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME
+		FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME IS NOT NULL
+		ORDER BY TABLE_NAME, ORDINAL_POSITION`
+}
+
+func (mysqlDialect) tableSizesQuery() string {
+	return `SELECT TABLE_NAME, COALESCE(TABLE_ROWS, 0), COALESCE(DATA_LENGTH, 0) + COALESCE(INDEX_LENGTH, 0)
+		FROM information_schema.TABLES
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE'
+		ORDER BY TABLE_NAME`
+}
+
+func (mysqlDialect) indexedColumnsQuery() string {
+	return `SELECT TABLE_NAME, COLUMN_NAME
+		FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND SEQ_IN_INDEX = 1`
+}
+
+func (mysqlDialect) timestampColumnsQuery() string {
+	return `SELECT TABLE_NAME, COLUMN_NAME, DATA_TYPE
+		FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND DATA_TYPE IN ('date', 'datetime', 'timestamp')
+		ORDER BY TABLE_NAME, ORDINAL_POSITION`
+}
+
+func (d mysqlDialect) Upsert(table string, columns []string) sq.InsertBuilder {
+	return d.Builder().Replace(table).Columns(columns...)
+}
+
+func (mysqlDialect) CreateTable(table string, columns []string) string {
+	return ""
+}
+
+func (d mysqlDialect) tablesQuery() string {
+	return informationSchemaTables(d)
+}
+
+func (d mysqlDialect) columnsQuery() string {
+	return informationSchemaColumns(d)
+}
+
+func (d mysqlDialect) primaryKeyQuery() string {
+	return informationSchemaPrimaryKey(d)
+}
+
+func (mysqlDialect) timeValue(t time.Time) any {
+	return t.Format(time.RFC3339)
+}
+
+// maxLockName is the longest lock name GET_LOCK accepts
+const maxLockName = 64
+
+// lockName returns the GET_LOCK name of the table, hashed when the readable
+// one is longer than GET_LOCK allows
+func (mysqlDialect) lockName(database string, table string) string {
+	name := fmt.Sprintf("archi:%s.%s", database, table)
+	if len(name) <= maxLockName {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(database + "." + table))
+
+	return "archi:" + hex.EncodeToString(sum[:])[:maxLockName-len("archi:")]
+}
+
+func (mysqlDialect) lock(ctx context.Context, conn *sql.Conn, name string, seconds int) (bool, error) {
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, seconds).Scan(&acquired); err != nil {
+		return false, err
+	}
+
+	return acquired.Int64 == 1, nil
+}
+
+func (mysqlDialect) unlock(ctx context.Context, conn *sql.Conn, name string) {
+	var released sql.NullInt64
+	conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", name).Scan(&released)
+}
+
+func (mysqlDialect) lockHolder(ctx context.Context, db *sql.DB, name string) (string, bool) {
+	var holder sql.NullInt64
+	db.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?)", name).Scan(&holder)
+
+	return fmt.Sprintf("connection %d", holder.Int64), holder.Valid
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return Postgres
+}
+
+func (postgresDialect) Builder() sq.StatementBuilderType {
+	return sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+}
+
+func (postgresDialect) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (postgresDialect) Schema() string {
+	return "current_schema()"
+}
+
+func (postgresDialect) MaxParams() int {
+	return 65535
+}
+
+func (postgresDialect) foreignKeysQuery() string {
+	return `SELECT kcu.table_name, kcu.column_name, ccu.table_name, ccu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON kcu.constraint_name = tc.constraint_name AND kcu.constraint_schema = tc.constraint_schema
+		JOIN information_schema.constraint_column_usage ccu
+			ON ccu.constraint_name = tc.constraint_name AND ccu.constraint_schema = tc.constraint_schema
+		WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = current_schema()
+		ORDER BY kcu.table_name, kcu.ordinal_position`
+}
+
+// tableSizesQuery reads reltuples, which is -1 for a table that was never
+// analyzed
+func (postgresDialect) tableSizesQuery() string {
+	return `SELECT c.relname, c.reltuples::bigint, pg_total_relation_size(c.oid)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p')
+		ORDER BY c.relname`
+}
+
+func (postgresDialect) indexedColumnsQuery() string {
+	return `SELECT t.relname, a.attname
+		FROM pg_index i
+		JOIN pg_class t ON t.oid = i.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = i.indkey[0]
+		WHERE n.nspname = current_schema()`
+}
+
+func (postgresDialect) timestampColumnsQuery() string {
+	return `SELECT table_name, column_name, data_type
+		FROM information_schema.columns
+		WHERE table_schema = current_schema()
+			AND data_type IN ('date', 'timestamp without time zone', 'timestamp with time zone')
+		ORDER BY table_name, ordinal_position`
+}
+
+func (d postgresDialect) Upsert(table string, columns []string) sq.InsertBuilder {
+	return d.Builder().Insert(table).Columns(columns...).Suffix("ON CONFLICT DO NOTHING")
+}
+
+func (postgresDialect) CreateTable(table string, columns []string) string {
+	return ""
+}
+
+func (d postgresDialect) tablesQuery() string {
+	return informationSchemaTables(d)
+}
+
+func (d postgresDialect) columnsQuery() string {
+	return informationSchemaColumns(d)
+}
+
+func (d postgresDialect) primaryKeyQuery() string {
+	return informationSchemaPrimaryKey(d)
+}
+
+func (postgresDialect) timeValue(t time.Time) any {
+	return t.Format(time.RFC3339)
+}
+
+func (postgresDialect) lockName(database string, table string) string {
+	return fmt.Sprintf("archi:%s.%s", database, table)
+}
+
+// advisoryKey turns a lock name into the key of an advisory lock
+func advisoryKey(name string) int64 {
+	sum := sha256.Sum256([]byte(name))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
+
+func (postgresDialect) lock(ctx context.Context, conn *sql.Conn, name string, seconds int) (bool, error) {
+	key := advisoryKey(name)
+
+	if seconds == 0 {
+		var acquired bool
+		err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired)
+
+		return acquired, err
+	}
+
+	// lock_timeout 0 waits without a limit
+	timeout := max(seconds, 0) * int(time.Second/time.Millisecond)
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET lock_timeout = %d", timeout)); err != nil {
+		return false, err
+	}
+	defer conn.ExecContext(context.Background(), "RESET lock_timeout")
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		if isLockNotAvailable(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// isLockNotAvailable tells whether the error is lock_not_available, 55P03,
+// raised when lock_timeout expires
+func isLockNotAvailable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "55P03"
+}
+
+func (postgresDialect) unlock(ctx context.Context, conn *sql.Conn, name string) {
+	var released bool
+	conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1)", advisoryKey(name)).Scan(&released)
+}
+
+// lockHolder returns the pid of the backend holding the advisory lock, whose
+// 64 bit key pg_locks splits into classid and objid
+func (postgresDialect) lockHolder(ctx context.Context, db *sql.DB, name string) (string, bool) {
+	key := uint64(advisoryKey(name))
+
+	var holder sql.NullInt64
+	db.QueryRowContext(ctx,
+		"SELECT pid FROM pg_locks WHERE locktype = 'advisory' AND granted AND classid = $1 AND objid = $2 AND objsubid = 1",
+		int64(key>>32), int64(key&0xffffffff),
+	).Scan(&holder)
+
+	return fmt.Sprintf("backend %d", holder.Int64), holder.Valid
+}
+
+// informationSchemaTables is the tablesQuery of the engines with an
+// information_schema
+func informationSchemaTables(d Dialect) string {
+	query, _, _ := d.Builder().
+		Select("TABLE_NAME").
+		From("information_schema.TABLES").
+		Where("TABLE_SCHEMA = " + d.Schema()).
+		OrderBy("TABLE_NAME").
+		ToSql()
+
+	return query
+}
+
+// informationSchemaColumns is the columnsQuery of the engines with an
+// information_schema
+func informationSchemaColumns(d Dialect) string {
+	query, _, _ := d.Builder().
+		Select("COLUMN_NAME").
+		From("information_schema.COLUMNS").
+		Where(sq.Expr("TABLE_SCHEMA = "+d.Schema())).
+		Where("TABLE_NAME = ?", "").
+		OrderBy("ORDINAL_POSITION").
+		ToSql()
+
+	return query
+}
+
+// informationSchemaPrimaryKey is the primaryKeyQuery of the engines with an
+// information_schema
+func informationSchemaPrimaryKey(d Dialect) string {
+	query, _, _ := d.Builder().
+		Select("kcu.COLUMN_NAME").
+		From("information_schema.TABLE_CONSTRAINTS tc").
+		Join("information_schema.KEY_COLUMN_USAGE kcu ON kcu.CONSTRAINT_NAME = tc.CONSTRAINT_NAME AND kcu.TABLE_SCHEMA = tc.TABLE_SCHEMA AND kcu.TABLE_NAME = tc.TABLE_NAME").
+		Where("tc.CONSTRAINT_TYPE = 'PRIMARY KEY'").
+		Where(sq.Expr("tc.TABLE_SCHEMA = "+d.Schema())).
+		Where("tc.TABLE_NAME = ?", "").
+		OrderBy("kcu.ORDINAL_POSITION").
+		ToSql()
+
+	return query
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// sqliteTimeLayout is how SQLite's date and time functions write timestamps
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return SQLite
+}
+
+func (sqliteDialect) Builder() sq.StatementBuilderType {
+	return sq.StatementBuilder.PlaceholderFormat(sq.Question)
+}
+
+func (sqliteDialect) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// Schema is the file of the main database, empty for an in-memory one
+func (sqliteDialect) Schema() string {
+	return "(SELECT file FROM pragma_database_list WHERE name = 'main')"
+}
+
+func (sqliteDialect) MaxParams() int {
+	return 32766
+}
+
+func (d sqliteDialect) Upsert(table string, columns []string) sq.InsertBuilder {
+	return d.Builder().Insert(table).Options("OR REPLACE").Columns(columns...)
+}
+
+// CreateTable creates the table without column types, which SQLite doesn't
+// need, keyed by the id when it is archived
+func (d sqliteDialect) CreateTable(table string, columns []string) string {
+	definitions := make([]string, 0, len(columns))
+	for _, column := range columns {
+		definition := d.QuoteIdent(column)
+		if column == "id" {
+			definition += " PRIMARY KEY"
+		}
+
+		definitions = append(definitions, definition)
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", d.QuoteIdent(table), strings.Join(definitions, ", "))
+}
+
+func (sqliteDialect) tablesQuery() string {
+	return "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name"
+}
+
+func (sqliteDialect) columnsQuery() string {
+	return "SELECT name FROM pragma_table_info(?) ORDER BY cid"
+}
+
+func (sqliteDialect) primaryKeyQuery() string {
+	return "SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk"
+}
+
+// foreignKeysQuery resolves a reference without columns to the primary key
+// of the referenced table
+func (sqliteDialect) foreignKeysQuery() string {
+	return `SELECT m.name, f."from", f."table",
+			COALESCE(f."to", (SELECT p.name FROM pragma_table_info(f."table") p WHERE p.pk = f.seq + 1), '')
+		FROM sqlite_master m
+		JOIN pragma_foreign_key_list(m.name) f
+		WHERE m.type = 'table'
+		ORDER BY m.name, f.id, f.seq`
+}
+
+// tableSizesQuery sums the pages of the tables and their indexes. SQLite
+// keeps no row estimates.
+func (sqliteDialect) tableSizesQuery() string {
+	return `SELECT m.tbl_name, -1, SUM(s.pgsize)
+		FROM sqlite_master m
+		JOIN dbstat s ON s.name = m.name
+		WHERE m.tbl_name IN (SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%')
+		GROUP BY m.tbl_name
+		ORDER BY m.tbl_name`
+}
+
+// indexedColumnsQuery counts the INTEGER PRIMARY KEY as indexed, since it is
+// the rowid the table is stored by
+func (sqliteDialect) indexedColumnsQuery() string {
+	return `SELECT m.name, ii.name
+		FROM sqlite_master m
+		JOIN pragma_index_list(m.name) il
+		JOIN pragma_index_info(il.name) ii
+		WHERE m.type = 'table' AND ii.seqno = 0
+		UNION
+		SELECT m.name, c.name
+		FROM sqlite_master m
+		JOIN pragma_table_info(m.name) c
+		WHERE m.type = 'table' AND c.pk = 1 AND upper(c.type) = 'INTEGER'`
+}
+
+// timestampColumnsQuery goes by the declared types and, since timestamps are
+// mostly kept in TEXT or INTEGER columns, by names such as created_at
+func (sqliteDialect) timestampColumnsQuery() string {
+	return `SELECT m.name, c.name, c.type
+		FROM sqlite_master m
+		JOIN pragma_table_info(m.name) c
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND (
+			upper(c.type) LIKE '%DATE%' OR upper(c.type) LIKE '%TIME%'
+			OR lower(c.name) LIKE '%\_at' ESCAPE '\' OR lower(c.name) LIKE '%date%' OR lower(c.name) LIKE '%time%'
+		)
+		ORDER BY m.name, c.cid`
+}
+
+// timeValue is in UTC because that is what CURRENT_TIMESTAMP and
+// datetime('now') write, and in their layout so the text comparison SQLite
+// does on timestamps holds
+func (sqliteDialect) timeValue(t time.Time) any {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+// lockName is the lock file of the table next to the database file, none
+// for an in-memory database no other process can reach
+func (sqliteDialect) lockName(database string, table string) string {
+	if database == "" {
+		return ""
+	}
+
+	safe := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+
+		return r
+	}, table)
+
+	return fmt.Sprintf("%s.archi-%s.lock", database, safe)
+}
+
+// lock creates the lock file, which fails while another run holds it
+func (sqliteDialect) lock(ctx context.Context, conn *sql.Conn, name string, seconds int) (bool, error) {
+	if name == "" {
+		return true, nil
+	}
+
+	deadline := time.Now().Add(time.Duration(seconds) * time.Second)
+
+	for {
+		file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_, err = fmt.Fprintln(file, os.Getpid())
+			file.Close()
+
+			return true, err
+		}
+
+		if !errors.Is(err, fs.ErrExist) {
+			return false, err
+		}
+
+		if seconds >= 0 && !time.Now().Before(deadline) {
+			return false, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func (sqliteDialect) unlock(ctx context.Context, conn *sql.Conn, name string) {
+	if name != "" {
+		os.Remove(name)
+	}
+}
+
+// lockHolder reads the pid from the lock file. A run that was killed leaves
+// its lock file behind, so the file has to be removed by hand then.
+func (sqliteDialect) lockHolder(ctx context.Context, db *sql.DB, name string) (string, bool) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return "", false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("process %d, remove the file if it isn't running anymore", pid), true
+}
+
+// sqliteFile returns the database file of an SQLite DSN
+func sqliteFile(dsn string) string {
+	file := strings.TrimPrefix(dsn, "file:")
+	if i := strings.IndexByte(file, '?'); i >= 0 {
+		file = file[:i]
+	}
+
+	return file
+}
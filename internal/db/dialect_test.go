@@ -0,0 +1,31 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsLockNotAvailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"lock_not_available", &pgconn.PgError{Code: "55P03", Message: "canceling statement due to lock timeout"}, true},
+		{"wrapped", fmt.Errorf("lock: %w", &pgconn.PgError{Code: "55P03"}), true},
+		{"query_canceled", &pgconn.PgError{Code: "57014"}, false},
+		{"code in the message only", errors.New(`relation "55P03" does not exist`), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLockNotAvailable(tt.err); got != tt.want {
+				t.Errorf("isLockNotAvailable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
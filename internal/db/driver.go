@@ -3,50 +3,72 @@ package db
 import (
 	"context"
 	"database/sql"
-	"encoding/csv"
 	"fmt"
-	"os"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
-	"github.com/fn3x/archivator/internal/helpers"
+	"github.com/fn3x/archivator/internal/filter"
 	"github.com/go-sql-driver/mysql"
 )
 
-type ArchiveConfig struct {
-	DB         *sql.DB
-	TargetDB   *sql.DB
-	Table      Table
-	CutoffDate time.Time
-	OutputDir  string
-	Limit      int32
-	Purge      bool
-}
-
-type ArchiveManyConfig struct {
-	DB         *sql.DB
-	TargetDB   *sql.DB
-	Tables     []Table
-	CutoffDate time.Time
-	OutputDir  string
-	Limit      int32
-	Purge      bool
-}
-
 type Table struct {
 	Name            string
 	TimestampCol    string
 	RefColumn       string
 	RefTable        string
 	RefTimestampCol string
+	OutputDir       string
+
+	// CutoffDate, Limit and Where override the run-wide values for this
+	// table. For a table with a reference table the cutoff applies to the
+	// timestamp column of the reference table, while Where always reads the
+	// columns of the table itself.
+	CutoffDate time.Time
+	Limit      int32
+	Where      *filter.Filter
+
+	// Columns lists the columns written to the archive, Exclude the ones
+	// left out when Columns is empty. Transforms change the values of
+	// columns before they are written.
+	Columns    []string
+	Exclude    []string
+	Transforms map[string]Transform
 }
 
-func NewArchiveConfig() *ArchiveConfig {
-	return &ArchiveConfig{}
+// WithDefaults returns the table with the run-wide values filled in where the
+// table doesn't override them
+func (t Table) WithDefaults(cutoffDate time.Time, limit int32, where *filter.Filter, outputDir string) Table {
+	if t.CutoffDate.IsZero() {
+		t.CutoffDate = cutoffDate
+	}
+
+	if t.Limit <= 0 {
+		t.Limit = limit
+	}
+
+	if t.Where == nil {
+		t.Where = where
+	}
+
+	if t.OutputDir == "" {
+		t.OutputDir = outputDir
+	}
+
+	return t
 }
 
-func NewArchiveManyConfig() *ArchiveManyConfig {
-	return &ArchiveManyConfig{}
+// Outputs tells whether the column is written to the archive
+func (t Table) Outputs(column string) bool {
+	if len(t.Columns) > 0 {
+		return slices.Contains(t.Columns, column)
+	}
+
+	return !slices.Contains(t.Exclude, column)
 }
 
 func ConnectDB(config *mysql.Config, ctx context.Context) (*sql.DB, error) {
@@ -63,370 +85,321 @@ func ConnectDB(config *mysql.Config, ctx context.Context) (*sql.DB, error) {
 	return db, nil
 }
 
-// archives to a file and returns slice of ids
-func archiveOldData(db *sql.DB, tableName string, timestampCol string, cutoffDate time.Time, limit int32) ([]uint64, error) {
-	ids := make([]uint64, 0, limit)
-
-	cutoffFormatted := cutoffDate.Format(time.RFC3339)
-	fmt.Printf("Archiving rows from %s with cutoff date %s\n", tableName, cutoffFormatted)
-
-	query, args, err := sq.Select("*").
-		From(tableName).
-		Where(fmt.Sprintf("%s < ?", timestampCol), cutoffFormatted).
-		Limit(uint64(limit)).
-		OrderBy("id").
-		ToSql()
+// ConnectPostgres connects to PostgreSQL with a connection string such as
+// "host=db1 user=archi dbname=shop" or a postgres:// URL
+func ConnectPostgres(dsn string, ctx context.Context) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
 
+	err = db.PingContext(ctx)
 	if err != nil {
-		return ids, err
+		db.Close()
+		return nil, err
 	}
 
-	fmt.Printf("Query:%s\nArgs:%+v\n\n", query, args)
+	return db, nil
+}
+
+// ConnectSQLite opens the SQLite database file of the DSN, which may carry
+// the driver's query parameters such as _pragma=busy_timeout(10000). Without
+// a busy_timeout pragma it waits 5s for a database another process writes to.
+func ConnectSQLite(dsn string, ctx context.Context) (*sql.DB, error) {
+	if !strings.Contains(dsn, "busy_timeout") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
 
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return ids, err
+		dsn += separator + "_pragma=busy_timeout(5000)"
 	}
-	defer rows.Close()
 
-	columns, err := rows.Columns()
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
-		return ids, err
+		return nil, err
 	}
 
-	filename := fmt.Sprintf("archived_%s_till_%s_at_%s.csv", tableName, timestampCol, time.Now().UTC().Format(time.RFC3339))
-
-	file, err := os.Create(filename)
+	err = db.PingContext(ctx)
 	if err != nil {
-		return ids, err
+		db.Close()
+		return nil, err
 	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
 
-	writer.Write(columns)
-
-	for rows.Next() {
-		values := make([]any, len(columns))
-		valuePtrs := make([]any, len(columns))
-
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
+	return db, nil
+}
 
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return ids, err
-		}
+// SelectBatch selects the next batch of rows of the table to archive: at
+// most Limit rows older than the cutoff with an id greater than afterID, in
+// the order of their ids. The caller closes the rows.
+func SelectBatch(logger *slog.Logger, db *sql.DB, table Table, afterID uint64) (*sql.Rows, error) {
+	logger.Debug("archiving rows", "cutoff", table.CutoffDate.Format(time.RFC3339), "limit", table.Limit)
 
-		record := make([]string, len(columns))
-		for i, val := range values {
-			if val == nil {
-				record[i] = ""
-			} else if b, ok := val.([]byte); ok {
-				record[i] = string(b)
-			} else {
-				record[i] = fmt.Sprintf("%v", val)
-				if columns[i] == "id" {
-					if v, ok := val.(uint64); ok {
-						ids = append(ids, v)
-					} else if v, ok := val.(int64); ok {
-						ids = append(ids, uint64(v))
-					} else if v, ok := val.(int32); ok {
-						ids = append(ids, uint64(v))
-					} else if v, ok := val.(uint32); ok {
-						ids = append(ids, uint64(v))
-					}
-				}
-			}
-		}
+	d := DialectOf(db)
 
-		writer.Write(record)
+	columns, err := selectColumns(db, table)
+	if err != nil {
+		return nil, err
 	}
 
-	return ids, nil
-}
+	builder := olderThanCutoff(d, d.Builder().Select(columns...).From(d.QuoteIdent(table.Name)), table)
 
-// archives to a file and returns slice of ids
-func archiveRelatedData(db *sql.DB, table Table, cutoffDate time.Time, limit int32) ([]uint64, error) {
-	ids := make([]uint64, 0, limit)
+	if afterID > 0 {
+		builder = builder.Where(sq.Gt{column(d, table.Name, "id"): afterID})
+	}
 
-	cutoffFormatted := cutoffDate.Format(time.RFC3339)
-	fmt.Printf("Archiving rows from %s with cutoff date %s\n", table.Name, cutoffFormatted)
+	if table.Where != nil {
+		builder = builder.Where(where(d, table))
+	}
 
-	query, args, err := sq.
-		Select(fmt.Sprintf("%s.*", table.Name)).
-		From(table.Name).
-		Join(fmt.Sprintf("%s ON %s.%s = %s.id", table.RefTable, table.Name, table.RefColumn, table.RefTable)).
-		Where(fmt.Sprintf("%s.%s < ?", table.RefTable, table.RefTimestampCol), cutoffFormatted).
-		Limit(uint64(limit)).
-		OrderBy("id").
+	query, args, err := builder.
+		Limit(uint64(table.Limit)).
+		OrderBy(column(d, table.Name, "id")).
 		ToSql()
 
-	fmt.Printf("Query:%s\nArgs:%+v\n\n", query, args)
-
 	if err != nil {
-		return ids, err
+		return nil, err
 	}
 
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return ids, err
-	}
-	defer rows.Close()
+	logQuery(logger, query, args)
 
-	columns, err := rows.Columns()
-	if err != nil {
-		return ids, err
-	}
+	return db.Query(query, args...)
+}
 
-	filename := fmt.Sprintf("archived_%s_till_%s_at_%s.csv", table.Name, cutoffFormatted, time.Now().UTC().Format(time.RFC3339))
+// CountRows counts the rows of the table older than the cutoff, which is
+// how many rows archiving it until none are left would archive
+func CountRows(logger *slog.Logger, db *sql.DB, table Table) (int64, error) {
+	d := DialectOf(db)
+	builder := olderThanCutoff(d, d.Builder().Select("COUNT(*)").From(d.QuoteIdent(table.Name)), table)
 
-	file, err := os.Create(filename)
-	if err != nil {
-		return ids, err
+	if table.Where != nil {
+		builder = builder.Where(where(d, table))
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return 0, err
+	}
 
-	writer.Write(columns)
+	logQuery(logger, query, args)
 
-	for rows.Next() {
-		values := make([]any, len(columns))
-		valuePtrs := make([]any, len(columns))
+	var count int64
+	err = db.QueryRow(query, args...).Scan(&count)
 
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
+	return count, err
+}
 
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return ids, err
-		}
+// olderThanCutoff narrows the select down to the rows of the table older
+// than its cutoff, joining the reference table for a table that has one
+func olderThanCutoff(d Dialect, builder sq.SelectBuilder, table Table) sq.SelectBuilder {
+	if table.TimestampCol != "" {
+		return builder.Where(column(d, table.Name, table.TimestampCol)+" < ?", d.timeValue(table.CutoffDate))
+	}
 
-		record := make([]string, len(columns))
-		for i, val := range values {
-			if val == nil {
-				record[i] = ""
-			} else if b, ok := val.([]byte); ok {
-				record[i] = string(b)
-			} else {
-				record[i] = fmt.Sprintf("%v", val)
-				if columns[i] == "id" {
-					if v, ok := val.(uint64); ok {
-						ids = append(ids, v)
-					} else if v, ok := val.(int64); ok {
-						ids = append(ids, uint64(v))
-					} else if v, ok := val.(int32); ok {
-						ids = append(ids, uint64(v))
-					} else if v, ok := val.(uint32); ok {
-						ids = append(ids, uint64(v))
-					}
-				}
-			}
-		}
+	return builder.
+		Join(joinReference(d, table)).
+		Where(column(d, table.RefTable, table.RefTimestampCol)+" < ?", d.timeValue(table.CutoffDate))
+}
 
-		writer.Write(record)
-	}
+// joinReference is the join of the table with its reference table
+func joinReference(d Dialect, table Table) string {
+	return fmt.Sprintf("%s ON %s = %s", d.QuoteIdent(table.RefTable), column(d, table.Name, table.RefColumn), column(d, table.RefTable, "id"))
+}
 
-	return ids, nil
+// column is the quoted column qualified with its quoted table
+func column(d Dialect, table string, name string) string {
+	return d.QuoteIdent(table) + "." + d.QuoteIdent(name)
 }
 
-func deleteArchivedData(db *sql.DB, table Table, ids []uint64) error {
-	query, args, err := sq.
-		Delete(table.Name).
-		Where(sq.Eq{"id": ids}).
-		ToSql()
+// selectColumns returns the select list for the table: all of its columns
+// unless Columns or Exclude narrow them down. The id is always selected
+// because the rows are purged by id, whether it is written or not.
+func selectColumns(db *sql.DB, table Table) ([]string, error) {
+	d := DialectOf(db)
+
+	if len(table.Columns) == 0 && len(table.Exclude) == 0 && len(table.Transforms) == 0 {
+		return []string{d.QuoteIdent(table.Name) + ".*"}, nil
+	}
 
+	all, err := TableColumns(db, table.Name)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Printf("Query:%s\nArgs:%+v\n\n", query, args)
+	for _, name := range slices.Concat(table.Columns, table.Exclude, slices.Collect(maps.Keys(table.Transforms))) {
+		if !slices.Contains(all, name) {
+			return nil, columnNotFound(name, table.Name, all)
+		}
+	}
 
-	_, err = db.Exec(query, args...)
-	return err
-}
+	var columns []string
 
-func deleteRelatedArchivedData(db *sql.DB, table Table, ids []uint64) error {
-	query, args, err := sq.
-		Delete(table.Name).
-		Where(sq.Eq{"id": ids}).
-		ToSql()
+	if len(table.Columns) > 0 {
+		for _, name := range table.Columns {
+			columns = append(columns, column(d, table.Name, name))
+		}
 
-	if err != nil {
-		return err
+		if !slices.Contains(table.Columns, "id") {
+			columns = append(columns, column(d, table.Name, "id"))
+		}
+	} else {
+		for _, name := range all {
+			if table.Outputs(name) || name == "id" {
+				columns = append(columns, column(d, table.Name, name))
+			}
+		}
 	}
 
-	fmt.Printf("Query:%s\nArgs:%+v\n\n", query, args)
+	return columns, nil
+}
 
-	_, err = db.Exec(query, args...)
+// IDValue reads an id column value as the driver returns it
+func IDValue(val any) (uint64, bool) {
+	switch v := val.(type) {
+	case uint64:
+		return v, true
+	case int64:
+		return uint64(v), true
+	case int32:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case []byte:
+		id, err := strconv.ParseUint(string(v), 10, 64)
+		return id, err == nil
+	}
 
-	return err
+	return 0, false
 }
 
-func Archive(config *ArchiveConfig) error {
-	if err := helpers.AssertError(config.Limit > 0, "Expected rows limit to be greater than zero"); err != nil {
-		return err
+// DeleteRows deletes the rows with the ids from the table and returns how
+// many were deleted. The ids are deleted in statements with as many of them
+// as the engine takes parameters.
+func DeleteRows(logger *slog.Logger, db *sql.DB, table Table, ids []uint64) (int64, error) {
+	d := DialectOf(db)
+
+	chunk := d.MaxParams()
+	if table.Where != nil {
+		_, whereArgs, _ := table.Where.ToSql()
+		chunk -= len(whereArgs)
 	}
 
-	table := config.Table
+	var deleted int64
 
-	if err := helpers.AssertError(table.Name != "", "Expected table to have a name"); err != nil {
-		return err
-	}
+	for chunkIds := range slices.Chunk(ids, max(chunk, 1)) {
+		builder := d.Builder().
+			Delete(d.QuoteIdent(table.Name)).
+			Where(sq.Eq{d.QuoteIdent("id"): chunkIds})
 
-	if table.TimestampCol == "" {
-		if err := helpers.AssertError(table.RefTable != "", "Expected table with no timestamp column to have reference table name"); err != nil {
-			return err
+		// rows that stopped matching the filter since they were archived stay
+		if table.Where != nil {
+			builder = builder.Where(where(d, table))
 		}
 
-		if err := helpers.AssertError(table.RefColumn != "", "Expected table with no timestamp column to have reference column name"); err != nil {
-			return err
-		}
+		query, args, err := builder.ToSql()
 
-		if err := helpers.AssertError(table.RefTimestampCol != "", "Expected table with no timestamp column to have reference timestamp column name"); err != nil {
-			return err
+		if err != nil {
+			return deleted, err
 		}
-	}
 
-	if table.TimestampCol == "" {
-		ids, err := archiveRelatedData(config.DB, table, config.CutoffDate, config.Limit)
+		logQuery(logger, query, args)
+
+		result, err := db.Exec(query, args...)
 		if err != nil {
-			return fmt.Errorf("failed to archive %s: %v\n", table.Name, err)
+			return deleted, err
 		}
 
-		if config.Purge {
-			if err := deleteArchivedData(config.DB, table, ids); err != nil {
-				return fmt.Errorf("failed to delete from %s: %v\n", table.Name, err)
-			}
-		}
-	} else {
-		ids, err := archiveOldData(config.DB, table.Name, table.TimestampCol, config.CutoffDate, config.Limit)
+		affected, err := result.RowsAffected()
 		if err != nil {
-			return fmt.Errorf("failed to archive %s: %v\n", table.Name, err)
+			return deleted, err
 		}
 
-		if config.Purge {
-			if err := deleteRelatedArchivedData(config.DB, table, ids); err != nil {
-				return fmt.Errorf("failed to delete from %s: %v\n", table.Name, err)
-			}
-		}
+		deleted += affected
 	}
 
-	return nil
+	return deleted, nil
 }
 
-func ArchiveMany(config *ArchiveManyConfig) error {
-	if err := helpers.AssertError(config.Limit > 0, "Expected rows limit to be greater than zero"); err != nil {
-		return err
+// RowsByID selects the columns of the rows of the table with the ids, keyed
+// by id. The columns have to include id.
+func RowsByID(db *sql.DB, table string, columns []string, ids []uint64) (map[uint64][]any, error) {
+	d := DialectOf(db)
+
+	idIndex := slices.Index(columns, "id")
+	if idIndex < 0 {
+		return nil, fmt.Errorf("the columns of %s don't include id", table)
 	}
 
-	tablesIds := make([]struct {
-		name string
-		ids  []uint64
-	}, 0, len(config.Tables))
+	quoted := make([]string, len(columns))
+	for i, name := range columns {
+		quoted[i] = d.QuoteIdent(name)
+	}
+
+	found := make(map[uint64][]any, len(ids))
+
+	for chunkIds := range slices.Chunk(ids, d.MaxParams()) {
+		query, args, err := d.Builder().
+			Select(quoted...).
+			From(d.QuoteIdent(table)).
+			Where(sq.Eq{d.QuoteIdent("id"): chunkIds}).
+			ToSql()
 
-	for _, table := range config.Tables {
-		if err := helpers.AssertError(table.Name != "", "Expected table to have a name"); err != nil {
-			return err
+		if err != nil {
+			return nil, err
 		}
 
-		if table.TimestampCol == "" {
-			if err := helpers.AssertError(table.RefTable != "", "Expected table with no timestamp column to have reference table name"); err != nil {
+		if err := func() error {
+			rows, err := db.Query(query, args...)
+			if err != nil {
 				return err
 			}
+			defer rows.Close()
 
-			if err := helpers.AssertError(table.RefColumn != "", "Expected table with no timestamp column to have reference column name"); err != nil {
-				return err
-			}
+			for rows.Next() {
+				values := make([]any, len(columns))
+				valuePtrs := make([]any, len(columns))
 
-			if err := helpers.AssertError(table.RefTimestampCol != "", "Expected table with no timestamp column to have reference timestamp column name"); err != nil {
-				return err
-			}
-		}
+				for i := range values {
+					valuePtrs[i] = &values[i]
+				}
 
-		if table.TimestampCol == "" {
-			ids, err := archiveRelatedData(config.DB, table, config.CutoffDate, config.Limit)
-			if err != nil {
-				return fmt.Errorf("failed to archive %s: %v\n", table.Name, err)
-			}
+				if err := rows.Scan(valuePtrs...); err != nil {
+					return err
+				}
 
-			if config.Purge {
-				tablesIds = append(tablesIds, struct {
-					name string
-					ids  []uint64
-				}{
-					name: table.Name,
-					ids:  ids,
-				})
-			}
-		} else {
-			ids, err := archiveOldData(config.DB, table.Name, table.TimestampCol, config.CutoffDate, config.Limit)
-			if err != nil {
-				return fmt.Errorf("failed to archive %s: %v\n", table.Name, err)
+				if id, ok := IDValue(values[idIndex]); ok {
+					found[id] = values
+				}
 			}
 
-			if config.Purge {
-				tablesIds = append(tablesIds, struct {
-					name string
-					ids  []uint64
-				}{
-					name: table.Name,
-					ids:  ids,
-				})
-			}
+			return rows.Err()
+		}(); err != nil {
+			return nil, err
 		}
 	}
 
-	if !config.Purge {
-		return nil
-	}
+	return found, nil
+}
 
-	for _, table := range config.Tables {
-		var ids []uint64
-	inner:
-		for _, v := range tablesIds {
-			if v.name == table.Name {
-				ids = v.ids
-				break inner
-			}
-		}
+// where returns the filter of the table qualified with the table name and
+// quoted for the dialect
+func where(d Dialect, table Table) sq.Sqlizer {
+	return table.Where.Qualified(table.Name).Quoted(d.QuoteIdent)
+}
 
-		if len(ids) == 0 {
-			fmt.Printf("No ids found for table %s. Not deleting rows\n", table.Name)
-			continue
-		}
+// maxLoggedArgs is how many query arguments are logged before the rest are
+// summarised, so a delete of thousands of ids stays one short line
+const maxLoggedArgs = 8
 
-		if table.TimestampCol == "" {
-			if err := deleteRelatedArchivedData(config.DB, table, ids); err != nil {
-				return fmt.Errorf("failed to delete from %s: %v\n", table.Name, err)
-			}
-		}
+func logQuery(logger *slog.Logger, query string, args []any) {
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
 	}
 
-	for _, table := range config.Tables {
-		var ids []uint64
-	inner_related:
-		for _, v := range tablesIds {
-			if v.name == table.Name {
-				ids = v.ids
-				break inner_related
-			}
-		}
-
-		if len(ids) == 0 {
-			fmt.Printf("No ids found for table %s. Not deleting rows\n", table.Name)
-			continue
-		}
-
-		if table.TimestampCol != "" {
-			if err := deleteArchivedData(config.DB, table, ids); err != nil {
-				return fmt.Errorf("failed to delete from %s: %v\n", table.Name, err)
-			}
-		}
+	logged := args
+	if len(args) > maxLoggedArgs {
+		logged = append(slices.Clip(args[:maxLoggedArgs]), fmt.Sprintf("... %d more", len(args)-maxLoggedArgs))
 	}
 
-	return nil
+	logger.Debug("query", "sql", query, "args", fmt.Sprint(logged), "argCount", len(args))
 }
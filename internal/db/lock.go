@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"time"
+)
+
+// TableLocks are the locks a run holds on its tables: GET_LOCK locks on
+// MySQL, advisory locks on PostgreSQL and lock files next to the database
+// file on SQLite. The first two are tied to the connection that took them,
+// so the connection is kept until they are released.
+type TableLocks struct {
+	conn    *sql.Conn
+	dialect Dialect
+	names   []string
+}
+
+// LockTables takes a lock per table, keyed by the database and table name,
+// so no other run archives or purges the tables at the same time. It waits
+// up to timeout for a lock another run holds, without a limit when timeout
+// is negative.
+func LockTables(ctx context.Context, logger *slog.Logger, db *sql.DB, tables []Table, timeout time.Duration) (*TableLocks, error) {
+	d := DialectOf(db)
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var database sql.NullString
+	if err := conn.QueryRowContext(ctx, "SELECT "+d.Schema()).Scan(&database); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// every run locks the tables in the same order so two runs waiting for
+	// each other's tables can't deadlock
+	names := make([]string, 0, len(tables))
+	for _, table := range tables {
+		names = append(names, table.Name)
+	}
+
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	seconds := -1
+	if timeout >= 0 {
+		seconds = int(math.Ceil(timeout.Seconds()))
+	}
+
+	locks := &TableLocks{conn: conn, dialect: d}
+
+	for _, table := range names {
+		name := d.lockName(database.String, table)
+
+		acquired, err := d.lock(ctx, conn, name, seconds)
+		if err != nil {
+			locks.Release()
+			return nil, fmt.Errorf("couldn't lock table %s: %v", table, err)
+		}
+
+		if !acquired {
+			locks.Release()
+
+			if holder, ok := d.lockHolder(ctx, db, name); ok {
+				return nil, fmt.Errorf("table %s is being archived by another run (lock %q held by %s), try again later or wait for it with --lock-timeout", table, name, holder)
+			}
+
+			return nil, fmt.Errorf("table %s is being archived by another run (lock %q), try again later or wait for it with --lock-timeout", table, name)
+		}
+
+		logger.Debug("locked table", "table", table, "lock", name)
+		locks.names = append(locks.names, name)
+	}
+
+	return locks, nil
+}
+
+// Release releases the locks and closes their connection
+func (l *TableLocks) Release() {
+	for _, name := range l.names {
+		l.dialect.unlock(context.Background(), l.conn, name)
+	}
+
+	l.names = nil
+	l.conn.Close()
+}
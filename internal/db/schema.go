@@ -0,0 +1,344 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/fn3x/archivator/internal/cutoff"
+)
+
+// Tables returns the tables of the current database
+func Tables(db *sql.DB) ([]string, error) {
+	return queryNames(db, DialectOf(db).tablesQuery())
+}
+
+// TableColumns returns the columns of the table in the current database in
+// their ordinal order. A table that does not exist has no columns.
+func TableColumns(db *sql.DB, table string) ([]string, error) {
+	return queryNames(db, DialectOf(db).columnsQuery(), table)
+}
+
+// PrimaryKey returns the columns of the primary key of the table, none when
+// it has no primary key
+func PrimaryKey(db *sql.DB, table string) ([]string, error) {
+	return queryNames(db, DialectOf(db).primaryKeyQuery(), table)
+}
+
+// queryNames runs a catalog query selecting table or column names
+func queryNames(db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// ForeignKey is a column of Table referencing RefColumn of RefTable
+type ForeignKey struct {
+	Table     string
+	Column    string
+	RefTable  string
+	RefColumn string
+}
+
+// ForeignKeys returns the foreign keys of all tables in the current database
+func ForeignKeys(db *sql.DB) ([]ForeignKey, error) {
+	rows, err := db.Query(DialectOf(db).foreignKeysQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []ForeignKey
+	for rows.Next() {
+		var key ForeignKey
+		if err := rows.Scan(&key.Table, &key.Column, &key.RefTable, &key.RefColumn); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// TableSize is the estimated number of rows and the size in bytes of the
+// data and indexes of a table
+type TableSize struct {
+	Name  string
+	Rows  int64
+	Bytes int64
+}
+
+// TableSizes returns the sizes of the tables in the current database. The
+// rows are estimated from the statistics of the engine and counted on SQLite,
+// which keeps none.
+func TableSizes(db *sql.DB) ([]TableSize, error) {
+	d := DialectOf(db)
+
+	rows, err := db.Query(d.tableSizesQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sizes []TableSize
+	for rows.Next() {
+		var size TableSize
+		if err := rows.Scan(&size.Name, &size.Rows, &size.Bytes); err != nil {
+			return nil, err
+		}
+
+		sizes = append(sizes, size)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, size := range sizes {
+		if size.Rows >= 0 {
+			continue
+		}
+
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + d.QuoteIdent(size.Name)).Scan(&sizes[i].Rows); err != nil {
+			return nil, err
+		}
+	}
+
+	return sizes, nil
+}
+
+// TimestampColumn is a date or time column of a table
+type TimestampColumn struct {
+	Table  string
+	Column string
+	Type   string
+}
+
+// TimestampColumns returns the date and time columns of all tables in the
+// current database, the candidates to archive the tables by
+func TimestampColumns(db *sql.DB) ([]TimestampColumn, error) {
+	rows, err := db.Query(DialectOf(db).timestampColumnsQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []TimestampColumn
+	for rows.Next() {
+		var column TimestampColumn
+		if err := rows.Scan(&column.Table, &column.Column, &column.Type); err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, column)
+	}
+
+	return columns, rows.Err()
+}
+
+// IndexedColumns returns the columns leading an index, whose ranges the
+// index serves, by table
+func IndexedColumns(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query(DialectOf(db).indexedColumnsQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexed := map[string][]string{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+
+		if !slices.Contains(indexed[table], column) {
+			indexed[table] = append(indexed[table], column)
+		}
+	}
+
+	return indexed, rows.Err()
+}
+
+// ValidateTables checks that every table exists and has the columns the
+// archiver reads from it, so no name reaches the database that isn't in its
+// catalog. The errors suggest the closest name for a misspelt one.
+func ValidateTables(db *sql.DB, tables []Table) error {
+	columnsCache := map[string][]string{}
+
+	tableColumns := func(table string) ([]string, error) {
+		if columns, ok := columnsCache[table]; ok {
+			return columns, nil
+		}
+
+		columns, err := TableColumns(db, table)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(columns) == 0 {
+			names, err := Tables(db)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, fmt.Errorf("table %s not found%s", table, didYouMean(table, names))
+		}
+
+		columnsCache[table] = columns
+
+		return columns, nil
+	}
+
+	check := func(table string, column string) error {
+		columns, err := tableColumns(table)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(columns, column) {
+			return columnNotFound(column, table, columns)
+		}
+
+		return nil
+	}
+
+	for _, table := range tables {
+		if err := check(table.Name, "id"); err != nil {
+			return err
+		}
+
+		if table.Where != nil {
+			for _, column := range table.Where.Columns() {
+				if err := check(table.Name, column); err != nil {
+					return fmt.Errorf("where of table %s: %v", table.Name, err)
+				}
+			}
+		}
+
+		for _, column := range slices.Concat(table.Columns, table.Exclude, slices.Sorted(maps.Keys(table.Transforms))) {
+			if err := check(table.Name, column); err != nil {
+				return err
+			}
+		}
+
+		if table.TimestampCol != "" {
+			if err := check(table.Name, table.TimestampCol); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := check(table.Name, table.RefColumn); err != nil {
+			return err
+		}
+
+		if err := check(table.RefTable, "id"); err != nil {
+			return err
+		}
+
+		if err := check(table.RefTable, table.RefTimestampCol); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OldestRow returns the timestamp of the oldest row of the table matching its
+// filter, read from the timestamp column of the reference table for a table
+// that has one. ok is false when the table has no such rows.
+func OldestRow(db *sql.DB, table Table) (oldest time.Time, ok bool, err error) {
+	d := DialectOf(db)
+
+	var builder sq.SelectBuilder
+
+	if table.TimestampCol != "" {
+		builder = d.Builder().
+			Select(fmt.Sprintf("MIN(%s)", column(d, table.Name, table.TimestampCol))).
+			From(d.QuoteIdent(table.Name))
+	} else {
+		builder = d.Builder().
+			Select(fmt.Sprintf("MIN(%s)", column(d, table.RefTable, table.RefTimestampCol))).
+			From(d.QuoteIdent(table.Name)).
+			Join(joinReference(d, table))
+	}
+
+	if table.Where != nil {
+		builder = builder.Where(where(d, table))
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	var value any
+	if err := db.QueryRow(query, args...).Scan(&value); err != nil {
+		return time.Time{}, false, err
+	}
+
+	return TimestampValue(value)
+}
+
+// ColumnRange returns the oldest and newest timestamp in the column of the
+// table. ok is false when the table has no rows with one.
+func ColumnRange(db *sql.DB, table string, name string) (oldest time.Time, newest time.Time, ok bool, err error) {
+	d := DialectOf(db)
+	quoted := d.QuoteIdent(name)
+
+	query := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s", quoted, quoted, d.QuoteIdent(table))
+
+	var minValue, maxValue any
+	if err := db.QueryRow(query).Scan(&minValue, &maxValue); err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+
+	if oldest, ok, err = TimestampValue(minValue); !ok || err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+
+	newest, ok, err = TimestampValue(maxValue)
+
+	return oldest, newest, ok, err
+}
+
+// TimestampValue reads a timestamp as the driver returns it, which is text
+// unless the driver parses it. ok is false for NULL.
+func TimestampValue(value any) (t time.Time, ok bool, err error) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, false, nil
+	case time.Time:
+		return v, true, nil
+	case []byte:
+		value = string(v)
+	}
+
+	for _, layout := range cutoff.Layouts {
+		if t, err := time.Parse(layout, fmt.Sprint(value)); err == nil {
+			return t, true, nil
+		}
+	}
+
+	return time.Time{}, false, fmt.Errorf("couldn't read %v as a timestamp", value)
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// columnNotFound is the error for a column missing from the table, naming
+// the closest of its columns
+func columnNotFound(column string, table string, columns []string) error {
+	return fmt.Errorf("column %s not found in table %s%s", column, table, didYouMean(column, columns))
+}
+
+// didYouMean suggests the name closest to name, none when every name is too
+// far off to be what was meant: more edits than a third of its length
+func didYouMean(name string, names []string) string {
+	best := ""
+	bestDistance := 0
+
+	for _, candidate := range names {
+		distance := editDistance(strings.ToLower(name), strings.ToLower(candidate))
+		if distance > max(max(len(name), len(candidate))/3, 1) {
+			continue
+		}
+
+		if best == "" || distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+
+	if best == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("; did you mean %s?", best)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	ar, br := []rune(a), []rune(b)
+
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(br)]
+}
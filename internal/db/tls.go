@@ -0,0 +1,134 @@
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	TLSDisabled       = "disabled"
+	TLSPreferred      = "preferred"
+	TLSRequired       = "required"
+	TLSVerifyCA       = "verify-ca"
+	TLSVerifyIdentity = "verify-identity"
+)
+
+var TLSModes = []string{TLSDisabled, TLSPreferred, TLSRequired, TLSVerifyCA, TLSVerifyIdentity}
+
+// TLSOptions mirrors the --ssl-* options of the mysql client
+type TLSOptions struct {
+	Mode       string
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// ConfigureTLS registers the TLS settings with the mysql driver under name and
+// points config at them. An empty mode means verify-identity when a CA file
+// is given and no TLS at all otherwise.
+func ConfigureTLS(config *mysql.Config, name string, opts TLSOptions) error {
+	mode := opts.Mode
+	if mode == "" {
+		if opts.CAFile == "" {
+			return nil
+		}
+
+		mode = TLSVerifyIdentity
+	}
+
+	switch mode {
+	case TLSDisabled:
+		config.TLSConfig = "false"
+		return nil
+	case TLSPreferred:
+		if opts.CAFile == "" && opts.CertFile == "" {
+			config.TLSConfig = "preferred"
+			return nil
+		}
+
+		config.AllowFallbackToPlaintext = true
+	case TLSRequired, TLSVerifyCA, TLSVerifyIdentity:
+	default:
+		return fmt.Errorf("unknown TLS mode %q, expected one of %v", mode, TLSModes)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: opts.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("couldn't read CA file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA file %s", opts.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("couldn't load client certificate: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch mode {
+	case TLSPreferred, TLSRequired:
+		tlsConfig.InsecureSkipVerify = true
+	case TLSVerifyCA:
+		// the chain is checked against the CA but the host name is not
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyChain(tlsConfig.RootCAs)
+	}
+
+	if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return err
+	}
+
+	config.TLSConfig = name
+
+	return nil
+}
+
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("server presented no certificate")
+		}
+
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+
+			certs[i] = cert
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+
+		return err
+	}
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Transform changes a column value on its way to the archive
+type Transform func(value any) any
+
+// ParseTransform parses a transform spec:
+//
+//	null          write NULL instead of the value
+//	hash          write the hex SHA-256 of salt and the value
+//	truncate:N    keep the first N characters
+//	const:VALUE   write VALUE instead of the value
+func ParseTransform(spec string, salt string) (Transform, error) {
+	name, arg, hasArg := strings.Cut(spec, ":")
+
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "null":
+		return func(any) any { return nil }, nil
+	case "hash":
+		if salt == "" {
+			return nil, fmt.Errorf("transform %q needs a salt", spec)
+		}
+
+		return func(value any) any {
+			if value == nil {
+				return nil
+			}
+
+			sum := sha256.Sum256(append([]byte(salt), valueBytes(value)...))
+			return hex.EncodeToString(sum[:])
+		}, nil
+	case "truncate":
+		n, err := strconv.Atoi(arg)
+		if !hasArg || err != nil || n < 0 {
+			return nil, fmt.Errorf("transform %q needs a length, e.g. truncate:3", spec)
+		}
+
+		return func(value any) any {
+			if value == nil {
+				return nil
+			}
+
+			s := string(valueBytes(value))
+			if utf8.RuneCountInString(s) <= n {
+				return s
+			}
+
+			return string([]rune(s)[:n])
+		}, nil
+	case "const":
+		if !hasArg {
+			return nil, fmt.Errorf("transform %q needs a value, e.g. const:REDACTED", spec)
+		}
+
+		return func(any) any { return arg }, nil
+	}
+
+	return nil, fmt.Errorf("unknown transform %q, expected null, hash, truncate:N or const:VALUE", spec)
+}
+
+func valueBytes(value any) []byte {
+	if b, ok := value.([]byte); ok {
+		return b
+	}
+
+	return fmt.Appendf(nil, "%v", value)
+}
@@ -0,0 +1,174 @@
+// Package filter parses the extra WHERE predicates given with --where or in
+// job files. Only column comparisons with literal values are accepted, the
+// values become placeholder arguments and the columns can be checked against
+// the table before the predicate gets anywhere near the database.
+//
+//	status IN ('closed', 'cancelled') AND NOT tenant_id = 42
+//	(deleted_at IS NOT NULL OR note LIKE 'test%') AND amount BETWEEN 0 AND 10
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Filter is a parsed predicate. It implements squirrel's Sqlizer, so it can be
+// passed to Where of any builder.
+type Filter struct {
+	root      node
+	source    string
+	qualifier string
+	quote     func(string) string
+}
+
+// Parse parses the predicate
+func Parse(expr string) (*Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid where %q: %v", expr, err)
+	}
+
+	p := &parser{tokens: tokens}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid where %q: %v", expr, err)
+	}
+
+	if !p.done() {
+		return nil, fmt.Errorf("invalid where %q: unexpected %s", expr, p.peek())
+	}
+
+	return &Filter{root: root, source: expr}, nil
+}
+
+// Qualified returns a copy of the filter whose columns are prefixed with
+// table, for queries that join other tables
+func (f *Filter) Qualified(table string) *Filter {
+	return &Filter{root: f.root, source: f.source, qualifier: table, quote: f.quote}
+}
+
+// Quoted returns a copy of the filter quoting identifiers with quote instead
+// of the backticks of MySQL
+func (f *Filter) Quoted(quote func(string) string) *Filter {
+	return &Filter{root: f.root, source: f.source, qualifier: f.qualifier, quote: quote}
+}
+
+// Columns returns the columns the filter reads, without duplicates
+func (f *Filter) Columns() []string {
+	var columns []string
+	seen := map[string]bool{}
+
+	f.root.columns(func(column string) {
+		if !seen[column] {
+			seen[column] = true
+			columns = append(columns, column)
+		}
+	})
+
+	return columns
+}
+
+func (f *Filter) String() string {
+	return f.source
+}
+
+func (f *Filter) ToSql() (string, []any, error) {
+	var b strings.Builder
+	var args []any
+
+	quote := f.quote
+	if quote == nil {
+		quote = quoteIdent
+	}
+
+	ident := func(column string) string {
+		if f.qualifier == "" {
+			return quote(column)
+		}
+
+		return quote(f.qualifier) + "." + quote(column)
+	}
+
+	f.root.write(&b, &args, ident)
+
+	return b.String(), args, nil
+}
+
+type node interface {
+	write(b *strings.Builder, args *[]any, ident func(string) string)
+	columns(add func(string))
+}
+
+type logical struct {
+	op    string
+	nodes []node
+}
+
+func (n *logical) write(b *strings.Builder, args *[]any, ident func(string) string) {
+	b.WriteByte('(')
+	for i, child := range n.nodes {
+		if i > 0 {
+			b.WriteString(" " + n.op + " ")
+		}
+
+		child.write(b, args, ident)
+	}
+	b.WriteByte(')')
+}
+
+func (n *logical) columns(add func(string)) {
+	for _, child := range n.nodes {
+		child.columns(add)
+	}
+}
+
+type not struct {
+	node node
+}
+
+func (n *not) write(b *strings.Builder, args *[]any, ident func(string) string) {
+	b.WriteString("NOT (")
+	n.node.write(b, args, ident)
+	b.WriteByte(')')
+}
+
+func (n *not) columns(add func(string)) {
+	n.node.columns(add)
+}
+
+// comparison is a column, an operator and its literal operands. The operator
+// is always one of the fixed strings produced by the parser.
+type comparison struct {
+	column string
+	op     string
+	values []any
+}
+
+func (n *comparison) write(b *strings.Builder, args *[]any, ident func(string) string) {
+	b.WriteString(ident(n.column))
+	b.WriteByte(' ')
+	b.WriteString(n.op)
+
+	switch n.op {
+	case "IS NULL", "IS NOT NULL":
+	case "IN", "NOT IN":
+		b.WriteString(" (")
+		b.WriteString(strings.TrimSuffix(strings.Repeat("?,", len(n.values)), ","))
+		b.WriteByte(')')
+	case "BETWEEN", "NOT BETWEEN":
+		b.WriteString(" ? AND ?")
+	default:
+		b.WriteString(" ?")
+	}
+
+	*args = append(*args, n.values...)
+}
+
+func (n *comparison) columns(add func(string)) {
+	add(n.column)
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
@@ -0,0 +1,249 @@
+package filter_test
+
+import (
+	"reflect"
+	"testing"
+
+	database "github.com/fn3x/archivator/internal/db"
+	"github.com/fn3x/archivator/internal/filter"
+)
+
+func sameArgs(got []any, want []any) bool {
+	if len(got) == 0 && len(want) == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(got, want)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr string
+		sql  string
+		args []any
+	}{
+		{"status = 'closed'", "`status` = ?", []any{"closed"}},
+		{"`order` = 1", "`order` = ?", []any{int64(1)}},
+		{"`we``ird` = 1", "`we``ird` = ?", []any{int64(1)}},
+		{"`with space` >= 2.5", "`with space` >= ?", []any{2.5}},
+		{"note = 'it''s'", "`note` = ?", []any{"it's"}},
+		{`note = 'it\'s'`, "`note` = ?", []any{"it's"}},
+		{`note = "double"`, "`note` = ?", []any{"double"}},
+		{`note = 'a\\b'`, "`note` = ?", []any{`a\b`}},
+		{"a <> -1", "`a` != ?", []any{int64(-1)}},
+		{"a != 1", "`a` != ?", []any{int64(1)}},
+		{"a < 1", "`a` < ?", []any{int64(1)}},
+		{"a <= 1", "`a` <= ?", []any{int64(1)}},
+		{"a > 1", "`a` > ?", []any{int64(1)}},
+		{"flag = TRUE", "`flag` = ?", []any{true}},
+		{"flag = false", "`flag` = ?", []any{false}},
+		{"status IN ('a', 'b', 3)", "`status` IN (?,?,?)", []any{"a", "b", int64(3)}},
+		{"status NOT IN ('a')", "`status` NOT IN (?)", []any{"a"}},
+		{"note LIKE 'x%'", "`note` LIKE ?", []any{"x%"}},
+		{"note NOT LIKE 'x%'", "`note` NOT LIKE ?", []any{"x%"}},
+		{"amount BETWEEN 0 AND 10.5", "`amount` BETWEEN ? AND ?", []any{int64(0), 10.5}},
+		{"amount NOT BETWEEN 1 AND 2", "`amount` NOT BETWEEN ? AND ?", []any{int64(1), int64(2)}},
+		{"deleted_at IS NULL", "`deleted_at` IS NULL", nil},
+		{"deleted_at IS NOT NULL", "`deleted_at` IS NOT NULL", nil},
+		{"NOT tenant_id = 42", "NOT (`tenant_id` = ?)", []any{int64(42)}},
+		{"NOT NOT a = 1", "NOT (NOT (`a` = ?))", []any{int64(1)}},
+		{"a = 1 OR b = 2 AND c = 3", "(`a` = ? OR (`b` = ? AND `c` = ?))", []any{int64(1), int64(2), int64(3)}},
+		{"a = 1 AND b = 2 OR c = 3", "((`a` = ? AND `b` = ?) OR `c` = ?)", []any{int64(1), int64(2), int64(3)}},
+		{"(a = 1 OR b = 2) AND c = 3", "((`a` = ? OR `b` = ?) AND `c` = ?)", []any{int64(1), int64(2), int64(3)}},
+		{"NOT (a = 1 OR b = 2)", "NOT ((`a` = ? OR `b` = ?))", []any{int64(1), int64(2)}},
+		{"NOT a = 1 AND b = 2", "(NOT (`a` = ?) AND `b` = ?)", []any{int64(1), int64(2)}},
+		{"status in ('a') and not x is null", "(`status` IN (?) AND NOT (`x` IS NULL))", []any{"a"}},
+		{"a = 1 AND b = 2 AND c = 3", "(`a` = ? AND `b` = ? AND `c` = ?)", []any{int64(1), int64(2), int64(3)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := filter.Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+
+			sql, args, err := f.ToSql()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sql != tt.sql {
+				t.Errorf("sql = %s, want %s", sql, tt.sql)
+			}
+
+			if !sameArgs(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+
+			if f.String() != tt.expr {
+				t.Errorf("String() = %q, want %q", f.String(), tt.expr)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []string{
+		"",
+		"a = b",
+		"a = `b`",
+		"lower(email) = 'x'",
+		"a = lower('x')",
+		"a = 1; DROP TABLE orders",
+		"a = 1;",
+		"a = 1 -- comment",
+		"a = 1 /* comment */",
+		"a = 1 # comment",
+		"a = 'unterminated",
+		`a = "unterminated`,
+		`a = 'escaped quote at the end\'`,
+		"`a = 1",
+		"`` = 1",
+		"a == 1",
+		"a =! 1",
+		"a = 1 AND",
+		"AND a = 1",
+		"a = 1 b = 2",
+		"(a = 1",
+		"a = 1)",
+		"a IN ()",
+		"a IN (1,)",
+		"a IN 1",
+		"a IN (b)",
+		"a NOT = 1",
+		"a IS 1",
+		"a IS NOT 'x'",
+		"a BETWEEN 1 OR 2",
+		"a LIKE b",
+		"a",
+		"1 = a",
+		"'a' = 1",
+		"a = NULL",
+		"a = 1.2.3",
+		"a = -",
+	}
+
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if f, err := filter.Parse(expr); err == nil {
+				sql, _, _ := f.ToSql()
+				t.Errorf("Parse(%q) = %s, want an error", expr, sql)
+			}
+		})
+	}
+}
+
+func TestColumns(t *testing.T) {
+	f, err := filter.Parse("(a = 1 OR b IS NULL) AND NOT a IN (2, 3) AND `c d` LIKE 'x'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a", "b", "c d"}
+	if got := f.Columns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Columns() = %v, want %v", got, want)
+	}
+}
+
+func TestQualifiedQuoted(t *testing.T) {
+	f, err := filter.Parse("status IN ('closed', 'cancelled') AND NOT note LIKE 'test%' AND amount BETWEEN 1 AND 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []any{"closed", "cancelled", "test%", int64(1), int64(5)}
+
+	tests := []struct {
+		engine string
+		table  string
+		where  string
+		query  string
+	}{
+		{
+			engine: database.MySQL,
+			table:  "orders",
+			where:  "(`orders`.`status` IN (?,?) AND NOT (`orders`.`note` LIKE ?) AND `orders`.`amount` BETWEEN ? AND ?)",
+			query:  "SELECT id FROM orders WHERE (`orders`.`status` IN (?,?) AND NOT (`orders`.`note` LIKE ?) AND `orders`.`amount` BETWEEN ? AND ?)",
+		},
+		{
+			engine: database.Postgres,
+			table:  "orders",
+			where:  `("orders"."status" IN (?,?) AND NOT ("orders"."note" LIKE ?) AND "orders"."amount" BETWEEN ? AND ?)`,
+			query:  `SELECT id FROM orders WHERE ("orders"."status" IN ($1,$2) AND NOT ("orders"."note" LIKE $3) AND "orders"."amount" BETWEEN $4 AND $5)`,
+		},
+		{
+			engine: database.SQLite,
+			table:  "orders",
+			where:  `("orders"."status" IN (?,?) AND NOT ("orders"."note" LIKE ?) AND "orders"."amount" BETWEEN ? AND ?)`,
+			query:  `SELECT id FROM orders WHERE ("orders"."status" IN (?,?) AND NOT ("orders"."note" LIKE ?) AND "orders"."amount" BETWEEN ? AND ?)`,
+		},
+		{
+			engine: database.MySQL,
+			table:  "we`ird",
+			where:  "(`we``ird`.`status` IN (?,?) AND NOT (`we``ird`.`note` LIKE ?) AND `we``ird`.`amount` BETWEEN ? AND ?)",
+			query:  "SELECT id FROM orders WHERE (`we``ird`.`status` IN (?,?) AND NOT (`we``ird`.`note` LIKE ?) AND `we``ird`.`amount` BETWEEN ? AND ?)",
+		},
+		{
+			engine: database.Postgres,
+			table:  `we"ird`,
+			where:  `("we""ird"."status" IN (?,?) AND NOT ("we""ird"."note" LIKE ?) AND "we""ird"."amount" BETWEEN ? AND ?)`,
+			query:  `SELECT id FROM orders WHERE ("we""ird"."status" IN ($1,$2) AND NOT ("we""ird"."note" LIKE $3) AND "we""ird"."amount" BETWEEN $4 AND $5)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.engine+" "+tt.table, func(t *testing.T) {
+			d, err := database.LookupDialect(tt.engine)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			where := f.Qualified(tt.table).Quoted(d.QuoteIdent)
+
+			sql, gotArgs, err := where.ToSql()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sql != tt.where {
+				t.Errorf("where = %s, want %s", sql, tt.where)
+			}
+
+			if !reflect.DeepEqual(gotArgs, args) {
+				t.Errorf("args = %#v, want %#v", gotArgs, args)
+			}
+
+			query, queryArgs, err := d.Builder().Select("id").From("orders").Where(where).ToSql()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if query != tt.query {
+				t.Errorf("query = %s, want %s", query, tt.query)
+			}
+
+			if !reflect.DeepEqual(queryArgs, args) {
+				t.Errorf("query args = %#v, want %#v", queryArgs, args)
+			}
+		})
+	}
+}
+
+func TestQuotedKeepsTheParsedFilter(t *testing.T) {
+	f, err := filter.Parse("a = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := database.LookupDialect(database.Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Qualified("t").Quoted(d.QuoteIdent)
+
+	if sql, _, _ := f.ToSql(); sql != "`a` = ?" {
+		t.Errorf("Qualified and Quoted changed the filter: %s", sql)
+	}
+}
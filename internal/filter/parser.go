@@ -0,0 +1,414 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenKeyword
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value any
+}
+
+func (t token) String() string {
+	if t.kind == tokenString {
+		return strconv.Quote(t.text)
+	}
+
+	return fmt.Sprintf("%q", t.text)
+}
+
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "IN": true, "IS": true,
+	"NULL": true, "LIKE": true, "BETWEEN": true, "TRUE": true, "FALSE": true,
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(expr); {
+		c := expr[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")"})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ","})
+			i++
+		case c == '\'' || c == '"':
+			value, n, err := readString(expr[i:])
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, token{kind: tokenString, text: value, value: value})
+			i += n
+		case c == '`':
+			name, n, err := readQuotedIdent(expr[i:])
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, token{kind: tokenIdent, text: name})
+			i += n
+		case c == '=' || c == '<' || c == '>' || c == '!':
+			op := string(c)
+			if i+1 < len(expr) && (expr[i+1] == '=' || expr[i+1] == '>') {
+				op = expr[i : i+2]
+			}
+
+			i += len(op)
+
+			switch op {
+			case "=", "!=", "<", "<=", ">", ">=":
+			case "<>":
+				op = "!="
+			default:
+				return nil, fmt.Errorf("unknown operator %q", op)
+			}
+
+			tokens = append(tokens, token{kind: tokenOperator, text: op})
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(expr) && (expr[j] == '.' || (expr[j] >= '0' && expr[j] <= '9')) {
+				j++
+			}
+
+			value, err := parseNumber(expr[i:j])
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, token{kind: tokenNumber, text: expr[i:j], value: value})
+			i = j
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(expr) && isIdentPart(expr[j]) {
+				j++
+			}
+
+			word := expr[i:j]
+			if keywords[strings.ToUpper(word)] {
+				tokens = append(tokens, token{kind: tokenKeyword, text: strings.ToUpper(word)})
+			} else {
+				tokens = append(tokens, token{kind: tokenIdent, text: word})
+			}
+
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+
+	return tokens, nil
+}
+
+func readString(s string) (string, int, error) {
+	quote := s[0]
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case s[i] == quote && i+1 < len(s) && s[i+1] == quote:
+			i++
+			b.WriteByte(quote)
+		case s[i] == quote:
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// readQuotedIdent reads a backtick quoted identifier, in which a doubled
+// backtick stands for one
+func readQuotedIdent(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '`' && i+1 < len(s) && s[i+1] == '`':
+			i++
+			b.WriteByte('`')
+		case s[i] == '`':
+			if b.Len() == 0 {
+				return "", 0, fmt.Errorf("empty quoted identifier")
+			}
+
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated quoted identifier")
+}
+
+func parseNumber(s string) (any, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+
+	return f, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c == '$' || (c >= '0' && c <= '9')
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() string {
+	if p.done() {
+		return "end of input"
+	}
+
+	return p.tokens[p.pos].String()
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	return !p.done() && p.tokens[p.pos].kind == tokenKeyword && p.tokens[p.pos].text == keyword
+}
+
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	if p.done() || p.tokens[p.pos].kind != kind {
+		return token{}, fmt.Errorf("expected %s, got %s", what, p.peek())
+	}
+
+	t := p.tokens[p.pos]
+	p.pos++
+
+	return t, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseLogical("OR", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseLogical("AND", p.parseNot)
+}
+
+func (p *parser) parseLogical(op string, next func() (node, error)) (node, error) {
+	first, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []node{first}
+	for p.acceptKeyword(op) {
+		n, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	if len(nodes) == 1 {
+		return first, nil
+	}
+
+	return &logical{op: op, nodes: nodes}, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.acceptKeyword("NOT") {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return &not{node: n}, nil
+	}
+
+	if !p.done() && p.tokens[p.pos].kind == tokenLParen {
+		p.pos++
+
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+
+		return n, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	column, err := p.expect(tokenIdent, "column name")
+	if err != nil {
+		return nil, err
+	}
+
+	n := &comparison{column: column.text}
+
+	if p.acceptKeyword("IS") {
+		n.op = "IS NULL"
+		if p.acceptKeyword("NOT") {
+			n.op = "IS NOT NULL"
+		}
+
+		if !p.acceptKeyword("NULL") {
+			return nil, fmt.Errorf("expected NULL after IS, got %s", p.peek())
+		}
+
+		return n, nil
+	}
+
+	negated := p.acceptKeyword("NOT")
+
+	switch {
+	case p.acceptKeyword("IN"):
+		n.op = "IN"
+
+		if _, err := p.expect(tokenLParen, "'(' after IN"); err != nil {
+			return nil, err
+		}
+
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+
+			n.values = append(n.values, value)
+
+			if !p.done() && p.tokens[p.pos].kind == tokenComma {
+				p.pos++
+				continue
+			}
+
+			break
+		}
+
+		if _, err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+	case p.acceptKeyword("LIKE"):
+		n.op = "LIKE"
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		n.values = []any{value}
+	case p.acceptKeyword("BETWEEN"):
+		n.op = "BETWEEN"
+
+		low, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.acceptKeyword("AND") {
+			return nil, fmt.Errorf("expected AND in BETWEEN, got %s", p.peek())
+		}
+
+		high, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		n.values = []any{low, high}
+	default:
+		if negated {
+			return nil, fmt.Errorf("expected IN, LIKE or BETWEEN after NOT, got %s", p.peek())
+		}
+
+		op, err := p.expect(tokenOperator, "comparison operator")
+		if err != nil {
+			return nil, err
+		}
+
+		n.op = op.text
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		n.values = []any{value}
+	}
+
+	if negated {
+		n.op = "NOT " + n.op
+	}
+
+	return n, nil
+}
+
+func (p *parser) parseValue() (any, error) {
+	if p.done() {
+		return nil, fmt.Errorf("expected a value, got end of input")
+	}
+
+	t := p.tokens[p.pos]
+
+	switch {
+	case t.kind == tokenString || t.kind == tokenNumber:
+		p.pos++
+		return t.value, nil
+	case t.kind == tokenKeyword && (t.text == "TRUE" || t.text == "FALSE"):
+		p.pos++
+		return t.text == "TRUE", nil
+	}
+
+	return nil, fmt.Errorf("expected a string or number, got %s", t)
+}
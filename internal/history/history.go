@@ -0,0 +1,330 @@
+// Package history records archive runs in the archi_runs table, one row per
+// run and table, so past runs can be looked up with archi history or SQL:
+//
+//	SELECT * FROM archi_runs
+//	WHERE table_name = 'payments' AND started_at >= '2025-04-01'
+package history
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/fn3x/archivator/archiver"
+	database "github.com/fn3x/archivator/internal/db"
+)
+
+const Table = "archi_runs"
+
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+const createTableMySQL = `CREATE TABLE IF NOT EXISTS archi_runs (
+	run_id VARCHAR(16) NOT NULL,
+	table_name VARCHAR(64) NOT NULL,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME NULL,
+	operator VARCHAR(255) NOT NULL,
+	host VARCHAR(255) NOT NULL,
+	source TEXT NOT NULL,
+	cutoff DATETIME NOT NULL,
+	rows_archived BIGINT NOT NULL DEFAULT 0,
+	rows_deleted BIGINT NOT NULL DEFAULT 0,
+	output TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	error TEXT NULL,
+	PRIMARY KEY (run_id, table_name),
+	KEY archi_runs_table_started (table_name, started_at)
+)`
+
+// createTableStatements creates archi_runs on PostgreSQL and SQLite, which
+// take the index in a statement of its own
+var createTableStatements = []string{
+	`CREATE TABLE IF NOT EXISTS archi_runs (
+	run_id VARCHAR(16) NOT NULL,
+	table_name VARCHAR(64) NOT NULL,
+	started_at TIMESTAMP NOT NULL,
+	finished_at TIMESTAMP NULL,
+	operator VARCHAR(255) NOT NULL,
+	host VARCHAR(255) NOT NULL,
+	source TEXT NOT NULL,
+	cutoff TIMESTAMP NOT NULL,
+	rows_archived BIGINT NOT NULL DEFAULT 0,
+	rows_deleted BIGINT NOT NULL DEFAULT 0,
+	output TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	error TEXT NULL,
+	PRIMARY KEY (run_id, table_name)
+)`,
+	`CREATE INDEX IF NOT EXISTS archi_runs_table_started ON archi_runs (table_name, started_at)`,
+}
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// Record is a row of archi_runs
+type Record struct {
+	RunID        string
+	Table        string
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Operator     string
+	Host         string
+	Source       string
+	CutoffDate   time.Time
+	RowsArchived int64
+	RowsDeleted  int64
+	Output       string
+	Status       string
+	Error        string
+}
+
+// EnsureTable creates archi_runs when it doesn't exist yet
+func EnsureTable(db *sql.DB) error {
+	if database.DialectOf(db).Name() == database.MySQL {
+		_, err := db.Exec(createTableMySQL)
+		return err
+	}
+
+	for _, statement := range createTableStatements {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Operator returns the name of the user running archi
+func Operator() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// Recorder writes the runs it observes to archi_runs. A run is inserted as
+// running when it starts and updated when it finishes. Failing to write the
+// history is logged and doesn't fail the run.
+type Recorder struct {
+	db       *sql.DB
+	source   string
+	operator string
+	host     string
+
+	mu       sync.Mutex
+	archived map[string]int64
+	deleted  map[string]int64
+}
+
+// NewRecorder returns a recorder writing to db, where source is the code or
+// job the runs were started with
+func NewRecorder(db *sql.DB, source string) *Recorder {
+	host, _ := os.Hostname()
+
+	return &Recorder{
+		db:       db,
+		source:   source,
+		operator: Operator(),
+		host:     host,
+	}
+}
+
+func (r *Recorder) Started(run archiver.Run) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.archived = map[string]int64{}
+	r.deleted = map[string]int64{}
+
+	// archi_runs has a row per table, a run without tables has none
+	if len(run.Tables) == 0 {
+		return
+	}
+
+	builder := database.DialectOf(r.db).Builder().Insert(Table).Columns(
+		"run_id", "table_name", "started_at", "operator", "host", "source", "cutoff", "output", "status",
+	)
+
+	for _, table := range run.Tables {
+		builder = builder.Values(
+			run.ID,
+			table.Name,
+			run.StartedAt.UTC().Format(timeLayout),
+			r.operator,
+			r.host,
+			r.source,
+			table.CutoffDate.UTC().Format(timeLayout),
+			table.Output,
+			StatusRunning,
+		)
+	}
+
+	query, args, err := builder.ToSql()
+	if err == nil {
+		_, err = r.db.Exec(query, args...)
+	}
+
+	if err != nil {
+		slog.Warn("couldn't record the run in the history", "run", run.ID, "err", err)
+	}
+}
+
+func (r *Recorder) Archived(table string, rows int, bytes int64, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.archived[table] += int64(rows)
+}
+
+func (r *Recorder) Deleted(table string, rows int64, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deleted[table] += rows
+}
+
+func (r *Recorder) Finished(run archiver.Run, runErr error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := StatusSucceeded
+	var message any
+
+	switch {
+	case errors.Is(runErr, context.Canceled):
+		status = StatusCancelled
+		message = runErr.Error()
+	case runErr != nil:
+		status = StatusFailed
+		message = strings.TrimSpace(runErr.Error())
+	}
+
+	finishedAt := time.Now().UTC().Format(timeLayout)
+
+	for _, table := range run.Tables {
+		// no file is written for a table without rows to archive
+		output := table.Output
+		if r.archived[table.Name] == 0 {
+			output = ""
+		}
+
+		builder := database.DialectOf(r.db).Builder().Update(Table).
+			Set("finished_at", finishedAt).
+			Set("output", output).
+			Set("rows_archived", r.archived[table.Name]).
+			Set("rows_deleted", r.deleted[table.Name]).
+			Set("status", status).
+			Set("error", message).
+			Where(sq.Eq{"run_id": run.ID, "table_name": table.Name})
+
+		query, args, err := builder.ToSql()
+		if err == nil {
+			_, err = r.db.Exec(query, args...)
+		}
+
+		if err != nil {
+			slog.Warn("couldn't record the run in the history", "run", run.ID, "table", table.Name, "err", err)
+		}
+	}
+}
+
+// Filter narrows down the records List returns. Zero values don't filter.
+type Filter struct {
+	RunID  string
+	Table  string
+	Status string
+	Since  time.Time
+	Until  time.Time
+	Limit  uint64
+}
+
+// List returns the records matching the filter, the latest runs first
+func List(db *sql.DB, filter Filter) ([]Record, error) {
+	builder := database.DialectOf(db).Builder().Select(
+		"run_id", "table_name", "started_at", "finished_at", "operator", "host", "source",
+		"cutoff", "rows_archived", "rows_deleted", "output", "status", "error",
+	).From(Table)
+
+	if filter.RunID != "" {
+		builder = builder.Where(sq.Eq{"run_id": filter.RunID})
+	}
+
+	if filter.Table != "" {
+		builder = builder.Where(sq.Eq{"table_name": filter.Table})
+	}
+
+	if filter.Status != "" {
+		builder = builder.Where(sq.Eq{"status": filter.Status})
+	}
+
+	if !filter.Since.IsZero() {
+		builder = builder.Where(sq.GtOrEq{"started_at": filter.Since.UTC().Format(timeLayout)})
+	}
+
+	if !filter.Until.IsZero() {
+		builder = builder.Where(sq.Lt{"started_at": filter.Until.UTC().Format(timeLayout)})
+	}
+
+	if filter.Limit > 0 {
+		builder = builder.Limit(filter.Limit)
+	}
+
+	query, args, err := builder.OrderBy("started_at DESC", "run_id", "table_name").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var record Record
+		var startedAt, finishedAt, cutoffDate, message sql.NullString
+
+		if err := rows.Scan(
+			&record.RunID, &record.Table, &startedAt, &finishedAt, &record.Operator, &record.Host, &record.Source,
+			&cutoffDate, &record.RowsArchived, &record.RowsDeleted, &record.Output, &record.Status, &message,
+		); err != nil {
+			return nil, err
+		}
+
+		record.StartedAt = parseTime(startedAt)
+		record.FinishedAt = parseTime(finishedAt)
+		record.CutoffDate = parseTime(cutoffDate)
+		record.Error = message.String
+
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func parseTime(value sql.NullString) time.Time {
+	if !value.Valid {
+		return time.Time{}
+	}
+
+	for _, layout := range []string{timeLayout, time.RFC3339} {
+		if t, err := time.Parse(layout, value.String); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,136 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fn3x/archivator/archiver"
+	database "github.com/fn3x/archivator/internal/db"
+)
+
+func connect(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := database.ConnectSQLite(filepath.Join(t.TempDir(), "history.db"), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := EnsureTable(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestRecorderRecordsRunsFailingValidation(t *testing.T) {
+	db := connect(t)
+
+	a := archiver.New(db)
+	a.Observer = NewRecorder(db, "test")
+
+	_, err := a.Archive(&archiver.Config{
+		Tables:     []archiver.Table{{Name: "missing", TimestampCol: "created_at"}},
+		CutoffDate: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		OutputDir:  t.TempDir(),
+		Limit:      10,
+	}, context.Background())
+	if err == nil {
+		t.Fatal("Archive of a missing table succeeded")
+	}
+
+	records, err := List(db, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+
+	record := records[0]
+	if record.Table != "missing" || record.Status != StatusFailed || !strings.Contains(record.Error, "missing") {
+		t.Errorf("record = %+v", record)
+	}
+
+	if record.FinishedAt.IsZero() {
+		t.Error("the failed run isn't finished")
+	}
+}
+
+func TestRecorderRecordsTheOutputs(t *testing.T) {
+	db := connect(t)
+
+	statements := []string{
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, created_at TIMESTAMP)",
+		"INSERT INTO orders (id, created_at) VALUES (1, '2024-01-01 00:00:00'), (2, '2024-02-01 00:00:00'), (3, '2026-01-01 00:00:00')",
+		"CREATE TABLE empty (id INTEGER PRIMARY KEY, created_at TIMESTAMP)",
+	}
+
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := archiver.New(db)
+	a.Observer = NewRecorder(db, "test")
+
+	result, err := a.Archive(&archiver.Config{
+		Tables: []archiver.Table{
+			{Name: "orders", TimestampCol: "created_at"},
+			{Name: "empty", TimestampCol: "created_at"},
+		},
+		CutoffDate: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		OutputDir:  t.TempDir(),
+		Limit:      10,
+		Purge:      true,
+	}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := List(db, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := map[string]string{}
+	for _, record := range records {
+		if record.Status != StatusSucceeded {
+			t.Errorf("record = %+v", record)
+		}
+
+		outputs[record.Table] = record.Output
+	}
+
+	if outputs["orders"] != result.Tables[0].Output || outputs["orders"] == "" {
+		t.Errorf("output of orders = %q, want %q", outputs["orders"], result.Tables[0].Output)
+	}
+
+	if outputs["empty"] != "" {
+		t.Errorf("output of empty = %q, want none", outputs["empty"])
+	}
+}
+
+func TestRecorderSkipsRunsWithoutTables(t *testing.T) {
+	db := connect(t)
+
+	recorder := NewRecorder(db, "test")
+	recorder.Started(archiver.Run{ID: "empty", StartedAt: time.Now()})
+	recorder.Finished(archiver.Run{ID: "empty"}, nil)
+
+	records, err := List(db, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 0 {
+		t.Errorf("got %d records, want none", len(records))
+	}
+}
@@ -0,0 +1,562 @@
+// Package job reads declarative archive jobs from YAML or JSON files.
+//
+//	name: nightly
+//	olderThan: 90d
+//	timezone: Europe/Berlin
+//	limit: 1000
+//	batches: 0 # until no rows are left
+//	purge: true
+//	where: tenant_id NOT IN (7, 12)
+//	salt: change-me # or ARCHI_MASK_SALT
+//	outputDir: /var/archive
+//	keep: 7y # archi prune deletes older archives
+//	lockTimeout: 5m # 0 fails right away, -1s waits without a limit
+//	sink: # the sink config key is used when not set
+//	  type: s3 # csv, jsonl, database or s3
+//	  bucket: archive
+//	  prefix: shop/
+//	tables:
+//	  - name: orders
+//	    timestampCol: created_at
+//	    where: status IN ('closed', 'cancelled')
+//	    exclude: [card_number]
+//	    mask:
+//	      email: hash
+//	      name: truncate:1
+//	      note: const:REDACTED
+//	      ip: null
+//	  - name: order_items
+//	    related:
+//	      table: orders
+//	      key: order_id
+//	      timestampCol: created_at
+//	    outputDir: /var/archive/items
+//	  - name: audit_log
+//	    timestampCol: logged_at
+//	    olderThan: 7y
+//	    limit: 5000
+//	    keep: 10y
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/fn3x/archivator/internal/cutoff"
+	database "github.com/fn3x/archivator/internal/db"
+	"github.com/fn3x/archivator/internal/filter"
+	"github.com/fn3x/archivator/internal/helpers"
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultLimit = 100
+
+type Job struct {
+	Name      string  `mapstructure:"name" yaml:"name,omitempty"`
+	Schedule  string  `mapstructure:"schedule" yaml:"schedule,omitempty"`
+	Cutoff    string  `mapstructure:"cutoff" yaml:"cutoff,omitempty"`
+	OlderThan string  `mapstructure:"olderThan" yaml:"olderThan,omitempty"`
+	Timezone  string  `mapstructure:"timezone" yaml:"timezone,omitempty"`
+	Limit     int32   `mapstructure:"limit" yaml:"limit,omitempty"`
+	Batches   int     `mapstructure:"batches" yaml:"batches,omitempty"`
+	Purge     bool    `mapstructure:"purge" yaml:"purge,omitempty"`
+	Where     string  `mapstructure:"where" yaml:"where,omitempty"`
+	Salt      string  `mapstructure:"salt" yaml:"salt,omitempty"`
+	OutputDir string  `mapstructure:"outputDir" yaml:"outputDir,omitempty"`
+	Tables    []Table `mapstructure:"tables" yaml:"tables,omitempty"`
+
+	// Keep is how long archive files are kept before archi prune removes
+	// them, for the tables without a keep of their own
+	Keep string `mapstructure:"keep" yaml:"keep,omitempty"`
+
+	// LockTimeout is how long to wait for tables another run is archiving
+	LockTimeout time.Duration `mapstructure:"lockTimeout" yaml:"lockTimeout,omitempty"`
+
+	// Sink options override the options of the sink config key
+	Sink map[string]string `mapstructure:"sink" yaml:"sink,omitempty"`
+}
+
+// Table settings override the job-wide cutoff and limit when set. A job whose
+// tables all have a cutoff or olderThan needs no job-wide one.
+type Table struct {
+	Name         string   `mapstructure:"name" yaml:"name,omitempty"`
+	TimestampCol string   `mapstructure:"timestampCol" yaml:"timestampCol,omitempty"`
+	Related      *Related `mapstructure:"related" yaml:"related,omitempty"`
+	OutputDir    string   `mapstructure:"outputDir" yaml:"outputDir,omitempty"`
+	Cutoff       string   `mapstructure:"cutoff" yaml:"cutoff,omitempty"`
+	OlderThan    string   `mapstructure:"olderThan" yaml:"olderThan,omitempty"`
+	Limit        int32    `mapstructure:"limit" yaml:"limit,omitempty"`
+	Where        string   `mapstructure:"where" yaml:"where,omitempty"`
+	Keep         string   `mapstructure:"keep" yaml:"keep,omitempty"`
+
+	Columns []string          `mapstructure:"columns" yaml:"columns,omitempty"`
+	Exclude []string          `mapstructure:"exclude" yaml:"exclude,omitempty"`
+	Mask    map[string]string `mapstructure:"mask" yaml:"mask,omitempty"`
+}
+
+// Related points a table without a timestamp column to the table whose
+// timestamp column decides which rows are archived
+type Related struct {
+	Table        string `mapstructure:"table" yaml:"table,omitempty"`
+	Key          string `mapstructure:"key" yaml:"key,omitempty"`
+	TimestampCol string `mapstructure:"timestampCol" yaml:"timestampCol,omitempty"`
+}
+
+// Load reads the job file at path. The format is taken from the extension.
+func Load(path string) (*Job, error) {
+	job := &Job{}
+	if err := decode(path, job); err != nil {
+		return nil, err
+	}
+
+	if job.Limit == 0 {
+		job.Limit = DefaultLimit
+	}
+
+	if err := job.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return job, nil
+}
+
+// LoadSet reads a file with a list of scheduled jobs under "jobs". Every job
+// needs a unique name and a cron schedule.
+//
+//	jobs:
+//	  - name: sessions
+//	    schedule: "*/15 * * * *"
+//	    olderThan: 30d
+//	    tables: ...
+func LoadSet(path string) ([]*Job, error) {
+	set := struct {
+		Jobs []*Job `mapstructure:"jobs"`
+	}{}
+
+	if err := decode(path, &set); err != nil {
+		return nil, err
+	}
+
+	if err := helpers.AssertError(len(set.Jobs) > 0, "Expected jobs file to have at least one job"); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(set.Jobs))
+
+	for i, job := range set.Jobs {
+		if job.Name == "" {
+			return nil, fmt.Errorf("%s: job #%d has no name", path, i+1)
+		}
+
+		if names[job.Name] {
+			return nil, fmt.Errorf("%s: job %s is listed more than once", path, job.Name)
+		}
+		names[job.Name] = true
+
+		if job.Schedule == "" {
+			return nil, fmt.Errorf("%s: job %s has no schedule", path, job.Name)
+		}
+
+		if job.Cutoff == "" && job.OlderThan == "" && job.needsCutoff() {
+			return nil, fmt.Errorf("%s: job %s needs cutoff or olderThan for the tables without one", path, job.Name)
+		}
+
+		if _, err := cron.ParseStandard(job.Schedule); err != nil {
+			return nil, fmt.Errorf("%s: job %s: invalid schedule %q: %v", path, job.Name, job.Schedule, err)
+		}
+
+		if job.Limit == 0 {
+			job.Limit = DefaultLimit
+		}
+
+		if err := job.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: job %s: %v", path, job.Name, err)
+		}
+	}
+
+	return set.Jobs, nil
+}
+
+// Save writes the job to path as YAML, or as JSON when path ends in .json
+func (j *Job) Save(path string) error {
+	var b bytes.Buffer
+
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(j); err != nil {
+		return err
+	}
+
+	out := b.Bytes()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var doc map[string]any
+		if err := yaml.Unmarshal(out, &doc); err != nil {
+			return err
+		}
+
+		var err error
+		if out, err = json.MarshalIndent(doc, "", "  "); err != nil {
+			return err
+		}
+
+		out = append(out, '\n')
+	}
+
+	return os.WriteFile(path, out, 0o644)
+}
+
+// decode reads the YAML or JSON file at path into out. The file is decoded
+// without viper, which lowercases map keys and so the columns of mask.
+func decode(path string, out any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("couldn't read job file: %v", err)
+	}
+
+	var doc map[string]any
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &doc)
+	case ".json":
+		err = json.Unmarshal(content, &doc)
+	default:
+		return fmt.Errorf("couldn't read job file %s: expected a .yaml, .yml or .json file", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("couldn't parse job file %s: %v", path, err)
+	}
+
+	var metadata mapstructure.Metadata
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           out,
+		Metadata:         &metadata,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			timeToStringHook,
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := decoder.Decode(doc); err != nil {
+		return fmt.Errorf("couldn't parse job file %s: %v", path, err)
+	}
+
+	// a misspelt key such as "were" would silently drop a filter or a mask
+	if len(metadata.Unused) > 0 {
+		slices.Sort(metadata.Unused)
+		return fmt.Errorf("couldn't parse job file %s: unknown keys: %s", path, strings.Join(metadata.Unused, ", "))
+	}
+
+	return nil
+}
+
+// timeToStringHook keeps YAML timestamps such as "cutoff: 2025-01-01" as
+// strings, since the YAML parser turns them into time.Time
+func timeToStringHook(_ reflect.Type, to reflect.Type, data any) (any, error) {
+	if t, ok := data.(time.Time); ok && to.Kind() == reflect.String {
+		return t.Format(time.RFC3339), nil
+	}
+
+	return data, nil
+}
+
+// Validate checks the job without looking at the database
+func (j *Job) Validate() error {
+	if err := helpers.AssertError(len(j.Tables) > 0, "Expected job to have at least one table"); err != nil {
+		return err
+	}
+
+	if err := helpers.AssertError(j.Limit > 0, "Expected rows limit to be greater than zero"); err != nil {
+		return err
+	}
+
+	if err := helpers.AssertError(j.Batches >= 0, "Expected batches not to be negative"); err != nil {
+		return err
+	}
+
+	if err := validateCutoff(j.Cutoff, j.OlderThan, j.Timezone); err != nil {
+		return err
+	}
+
+	if j.Where != "" {
+		if _, err := filter.Parse(j.Where); err != nil {
+			return err
+		}
+	}
+
+	if j.Keep != "" {
+		if _, err := cutoff.ParseAge(j.Keep); err != nil {
+			return fmt.Errorf("keep: %v", err)
+		}
+	}
+
+	if sink := j.Sink["type"]; sink != "" && !slices.Contains(archiver.SinkNames(), strings.ToLower(sink)) {
+		return fmt.Errorf("unknown sink %q, expected one of: %s", sink, strings.Join(archiver.SinkNames(), ", "))
+	}
+
+	seen := make(map[string]bool, len(j.Tables))
+
+	for i, table := range j.Tables {
+		if table.Name == "" {
+			return fmt.Errorf("table #%d has no name", i+1)
+		}
+
+		if seen[table.Name] {
+			return fmt.Errorf("table %s is listed more than once", table.Name)
+		}
+		seen[table.Name] = true
+
+		if table.TimestampCol != "" && table.Related != nil {
+			return fmt.Errorf("table %s has both timestampCol and related", table.Name)
+		}
+
+		if table.TimestampCol == "" && table.Related == nil {
+			return fmt.Errorf("table %s needs either timestampCol or related", table.Name)
+		}
+
+		if table.Related != nil {
+			if table.Related.Table == "" || table.Related.Key == "" || table.Related.TimestampCol == "" {
+				return fmt.Errorf("related of table %s needs table, key and timestampCol", table.Name)
+			}
+		}
+
+		if table.Limit < 0 {
+			return fmt.Errorf("limit of table %s must not be negative", table.Name)
+		}
+
+		if err := validateCutoff(table.Cutoff, table.OlderThan, j.Timezone); err != nil {
+			return fmt.Errorf("table %s: %v", table.Name, err)
+		}
+
+		if table.Where != "" {
+			if _, err := filter.Parse(table.Where); err != nil {
+				return fmt.Errorf("table %s: %v", table.Name, err)
+			}
+		}
+
+		if table.Keep != "" {
+			if _, err := cutoff.ParseAge(table.Keep); err != nil {
+				return fmt.Errorf("table %s: keep: %v", table.Name, err)
+			}
+		}
+
+		if len(table.Columns) > 0 && len(table.Exclude) > 0 {
+			return fmt.Errorf("table %s has both columns and exclude", table.Name)
+		}
+
+		if _, err := ParseMask(table.Mask, j.MaskSalt()); err != nil {
+			return fmt.Errorf("table %s: %v", table.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// needsCutoff tells whether a table of the job has no cutoff of its own, so
+// it is archived by the job-wide one
+func (j *Job) needsCutoff() bool {
+	return slices.ContainsFunc(j.Tables, func(t Table) bool { return t.Cutoff == "" && t.OlderThan == "" })
+}
+
+// validateCutoff checks the syntax of the cutoff settings, the actual
+// cutoff is only computed when the job runs
+func validateCutoff(expr string, olderThan string, timezone string) error {
+	if expr != "" && olderThan != "" {
+		return fmt.Errorf("cutoff and olderThan can't be used together")
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return fmt.Errorf("unknown timezone %q: %v", timezone, err)
+	}
+
+	if expr != "" {
+		if _, err := cutoff.Parse(expr, time.Now(), loc); err != nil {
+			return err
+		}
+	}
+
+	if olderThan != "" {
+		if _, err := cutoff.ParseAge(olderThan); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DatabaseTables converts the job tables to the tables used by the archiver.
+// Per-table cutoffs are computed from now in loc.
+func (j *Job) DatabaseTables(now time.Time, loc *time.Location) ([]database.Table, error) {
+	tables := make([]database.Table, 0, len(j.Tables))
+
+	for _, t := range j.Tables {
+		table := database.Table{
+			Name:         t.Name,
+			TimestampCol: t.TimestampCol,
+			OutputDir:    t.OutputDir,
+			Limit:        t.Limit,
+		}
+
+		if t.Cutoff != "" || t.OlderThan != "" {
+			cutoffDate, err := cutoff.Resolve(t.Cutoff, t.OlderThan, now, loc)
+			if err != nil {
+				return nil, fmt.Errorf("table %s: %v", t.Name, err)
+			}
+
+			table.CutoffDate = cutoffDate
+		}
+
+		table.Columns = t.Columns
+		table.Exclude = t.Exclude
+
+		transforms, err := ParseMask(t.Mask, j.MaskSalt())
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %v", t.Name, err)
+		}
+
+		table.Transforms = transforms
+
+		if t.Where != "" {
+			where, err := filter.Parse(t.Where)
+			if err != nil {
+				return nil, fmt.Errorf("table %s: %v", t.Name, err)
+			}
+
+			table.Where = where
+		}
+
+		if t.Related != nil {
+			table.RefTable = t.Related.Table
+			table.RefColumn = t.Related.Key
+			table.RefTimestampCol = t.Related.TimestampCol
+		}
+
+		tables = append(tables, table)
+	}
+
+	return tables, nil
+}
+
+// ArchiveConfig builds the archiver config for a run of the job that starts
+// at now. The caller fills in the connections.
+func (j *Job) ArchiveConfig(now time.Time) (*archiver.Config, error) {
+	loc, err := time.LoadLocation(j.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q: %v", j.Timezone, err)
+	}
+
+	config := archiver.NewConfig()
+	config.Limit = j.Limit
+	config.Batches = j.Batches
+	config.Purge = j.Purge
+	config.OutputDir = j.OutputDir
+	config.LockTimeout = j.LockTimeout
+
+	// a job whose tables all have a cutoff needs none of its own
+	if j.Cutoff != "" || j.OlderThan != "" || j.needsCutoff() {
+		config.CutoffDate, err = cutoff.Resolve(j.Cutoff, j.OlderThan, now, loc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if j.Where != "" {
+		config.Where, err = filter.Parse(j.Where)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config.Tables, err = j.DatabaseTables(now, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Retention is how long the archive files of a table are kept and the
+// directory they are written to, empty for the outputDir config key
+type Retention struct {
+	Table     string
+	Keep      string
+	OutputDir string
+}
+
+// Retentions returns the retention of the tables with a keep, of their own or
+// of the job
+func (j *Job) Retentions() []Retention {
+	var retentions []Retention
+
+	for _, t := range j.Tables {
+		retention := Retention{Table: t.Name, Keep: t.Keep, OutputDir: t.OutputDir}
+
+		if retention.Keep == "" {
+			retention.Keep = j.Keep
+		}
+
+		if retention.OutputDir == "" {
+			retention.OutputDir = j.OutputDir
+		}
+
+		if retention.Keep != "" {
+			retentions = append(retentions, retention)
+		}
+	}
+
+	return retentions
+}
+
+// MaskSalt returns the salt for hash masks. ARCHI_MASK_SALT wins over the
+// salt of the job file, so the salt doesn't have to be committed with it.
+func (j *Job) MaskSalt() string {
+	if salt := os.Getenv("ARCHI_MASK_SALT"); salt != "" {
+		return salt
+	}
+
+	return j.Salt
+}
+
+// ParseMask turns column to transform spec pairs into transforms
+func ParseMask(mask map[string]string, salt string) (map[string]database.Transform, error) {
+	if len(mask) == 0 {
+		return nil, nil
+	}
+
+	transforms := make(map[string]database.Transform, len(mask))
+	for column, spec := range mask {
+		// YAML reads "ip: null" as an empty value
+		if spec == "" {
+			spec = "null"
+		}
+
+		transform, err := database.ParseTransform(spec, salt)
+		if err != nil {
+			return nil, fmt.Errorf("mask of column %s: %v", column, err)
+		}
+
+		transforms[column] = transform
+	}
+
+	return transforms, nil
+}
@@ -0,0 +1,324 @@
+package job
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeJob(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadKeepsCaseOfMaskColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "job.yaml",
+			content: `olderThan: 90d
+lockTimeout: 5m
+cutoff: 2025-01-01
+sink:
+  type: csv
+tables:
+  - name: requests
+    timestampCol: requestTime
+    mask:
+      clientIP: hash
+      userAgent: null
+`,
+		},
+		{
+			name: "json",
+			file: "job.json",
+			content: `{
+  "olderThan": "90d",
+  "lockTimeout": "5m",
+  "cutoff": "2025-01-01",
+  "sink": {"type": "csv"},
+  "tables": [
+    {"name": "requests", "timestampCol": "requestTime", "mask": {"clientIP": "hash", "userAgent": null}}
+  ]
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeJob(t, tt.file, tt.content)
+
+			// olderThan and cutoff can't be used together, so decode
+			// without validating
+			j := &Job{}
+			if err := decode(path, j); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(j.Tables) != 1 {
+				t.Fatalf("got %d tables, want 1", len(j.Tables))
+			}
+
+			table := j.Tables[0]
+
+			if table.TimestampCol != "requestTime" {
+				t.Errorf("timestampCol = %q, want requestTime", table.TimestampCol)
+			}
+
+			if spec, ok := table.Mask["clientIP"]; !ok || spec != "hash" {
+				t.Errorf("mask = %v, want clientIP: hash", table.Mask)
+			}
+
+			if spec, ok := table.Mask["userAgent"]; !ok || spec != "" {
+				t.Errorf("mask = %v, want userAgent with an empty spec", table.Mask)
+			}
+
+			if j.LockTimeout != 5*time.Minute {
+				t.Errorf("lockTimeout = %v, want 5m", j.LockTimeout)
+			}
+
+			if j.Cutoff == "" {
+				t.Errorf("cutoff is empty")
+			}
+
+			if j.Sink["type"] != "csv" {
+				t.Errorf("sink = %v, want type: csv", j.Sink)
+			}
+		})
+	}
+}
+
+func TestLoadMixedCaseMask(t *testing.T) {
+	path := writeJob(t, "job.yaml", `olderThan: 90d
+salt: test
+tables:
+  - name: requests
+    timestampCol: requestTime
+    mask:
+      clientIP: hash
+`)
+
+	j, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transforms, err := ParseMask(j.Tables[0].Mask, j.MaskSalt())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := transforms["clientIP"]; !ok {
+		t.Errorf("transforms = %v, want one for clientIP", transforms)
+	}
+}
+
+func TestLoadRejectsUnknownExtension(t *testing.T) {
+	path := writeJob(t, "job.toml", "olderThan = \"90d\"\n")
+
+	if _, err := Load(path); err == nil {
+		t.Error("Load of a .toml file succeeded")
+	}
+}
+
+func TestLoadSet(t *testing.T) {
+	path := writeJob(t, "jobs.yaml", `jobs:
+  - name: requests
+    schedule: "@daily"
+    olderThan: 30d
+    tables:
+      - name: requests
+        timestampCol: requestTime
+`)
+
+	jobs, err := LoadSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 1 || jobs[0].Name != "requests" || jobs[0].Tables[0].TimestampCol != "requestTime" {
+		t.Errorf("LoadSet = %+v", jobs)
+	}
+
+	if jobs[0].Limit != DefaultLimit {
+		t.Errorf("limit = %d, want %d", jobs[0].Limit, DefaultLimit)
+	}
+}
+
+func TestArchiveConfigWithoutJobCutoff(t *testing.T) {
+	path := writeJob(t, "job.yaml", `tables:
+  - name: orders
+    timestampCol: created_at
+    olderThan: 30d
+  - name: audit_log
+    timestampCol: logged_at
+    cutoff: 2025-01-01
+`)
+
+	j, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2025, time.March, 31, 0, 0, 0, 0, time.UTC)
+
+	config, err := j.ArchiveConfig(now)
+	if err != nil {
+		t.Fatalf("ArchiveConfig: %v", err)
+	}
+
+	if !config.CutoffDate.IsZero() {
+		t.Errorf("job cutoff = %v, want none", config.CutoffDate)
+	}
+
+	want := []time.Time{
+		time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for i, table := range config.Tables {
+		if !table.CutoffDate.Equal(want[i]) {
+			t.Errorf("cutoff of %s = %v, want %v", table.Name, table.CutoffDate, want[i])
+		}
+	}
+}
+
+func TestArchiveConfigNeedsJobCutoffForTablesWithoutOne(t *testing.T) {
+	path := writeJob(t, "job.yaml", `tables:
+  - name: orders
+    timestampCol: created_at
+    olderThan: 30d
+  - name: order_items
+    related:
+      table: orders
+      key: order_id
+      timestampCol: created_at
+`)
+
+	j, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := j.ArchiveConfig(time.Now()); err == nil {
+		t.Error("ArchiveConfig succeeded without a cutoff for order_items")
+	}
+
+	j.OlderThan = "90d"
+
+	config, err := j.ArchiveConfig(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.CutoffDate.IsZero() {
+		t.Error("the job cutoff wasn't resolved")
+	}
+}
+
+func TestLoadSetCutoffs(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		ok      bool
+	}{
+		{
+			name: "every table has a cutoff",
+			content: `jobs:
+  - name: logs
+    schedule: "@daily"
+    tables:
+      - name: requests
+        timestampCol: requestTime
+        olderThan: 30d
+      - name: audit_log
+        timestampCol: logged_at
+        cutoff: today-7y
+`,
+			ok: true,
+		},
+		{
+			name: "a table without a cutoff",
+			content: `jobs:
+  - name: logs
+    schedule: "@daily"
+    tables:
+      - name: requests
+        timestampCol: requestTime
+        olderThan: 30d
+      - name: audit_log
+        timestampCol: logged_at
+`,
+		},
+		{
+			name: "a job cutoff for the table without one",
+			content: `jobs:
+  - name: logs
+    schedule: "@daily"
+    olderThan: 1y
+    tables:
+      - name: requests
+        timestampCol: requestTime
+        olderThan: 30d
+      - name: audit_log
+        timestampCol: logged_at
+`,
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSet(writeJob(t, "jobs.yaml", tt.content))
+			if tt.ok && err != nil {
+				t.Errorf("LoadSet: %v", err)
+			}
+
+			if !tt.ok && err == nil {
+				t.Error("LoadSet succeeded")
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	job := `olderThan: 90d
+tables:
+  - name: orders
+    timestampCol: created_at
+    were: status = 'closed'
+`
+
+	_, err := Load(writeJob(t, "job.yaml", job))
+	if err == nil || !strings.Contains(err.Error(), "tables[0].were") {
+		t.Errorf("Load = %v, want an error naming tables[0].were", err)
+	}
+
+	set := `jobs:
+  - name: orders
+    schedule: "@daily"
+    olderThan: 90d
+    were: status = 'closed'
+    tables:
+      - name: orders
+        timestampCol: created_at
+`
+
+	_, err = LoadSet(writeJob(t, "jobs.yaml", set))
+	if err == nil || !strings.Contains(err.Error(), "jobs[0].were") {
+		t.Errorf("LoadSet = %v, want an error naming jobs[0].were", err)
+	}
+}
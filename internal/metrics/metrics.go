@@ -0,0 +1,125 @@
+// Package metrics collects Prometheus metrics of archive runs, to be served
+// over HTTP by the daemon or written to a node_exporter textfile after ve.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fn3x/archivator/archiver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	rowsArchived   *prometheus.CounterVec
+	rowsDeleted    *prometheus.CounterVec
+	bytesWritten   *prometheus.CounterVec
+	batchDuration  *prometheus.HistogramVec
+	deleteDuration *prometheus.HistogramVec
+	errors         *prometheus.CounterVec
+	lastSuccess    *prometheus.GaugeVec
+	oldestRowAge   *prometheus.GaugeVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		rowsArchived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "archi_rows_archived_total",
+			Help: "Rows written to the archive.",
+		}, []string{"job", "table"}),
+		rowsDeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "archi_rows_deleted_total",
+			Help: "Rows deleted from the source after they were archived.",
+		}, []string{"job", "table"}),
+		bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "archi_bytes_written_total",
+			Help: "Bytes written to the archive files.",
+		}, []string{"job", "table"}),
+		batchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "archi_batch_duration_seconds",
+			Help:    "Time it took to read and write out a batch of rows.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}, []string{"job", "table"}),
+		deleteDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "archi_delete_duration_seconds",
+			Help:    "Time it took to delete a batch of archived rows.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}, []string{"job", "table"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "archi_errors_total",
+			Help: "Archive runs that failed.",
+		}, []string{"job"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "archi_last_success_timestamp_seconds",
+			Help: "Unix time of the last archive run that finished without errors.",
+		}, []string{"job"}),
+		oldestRowAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "archi_oldest_row_age_seconds",
+			Help: "Age of the oldest row left in the table after the last successful run, 0 when the table is empty.",
+		}, []string{"job", "table"}),
+	}
+
+	m.registry.MustRegister(
+		m.rowsArchived,
+		m.rowsDeleted,
+		m.bytesWritten,
+		m.batchDuration,
+		m.deleteDuration,
+		m.errors,
+		m.lastSuccess,
+		m.oldestRowAge,
+	)
+
+	return m
+}
+
+// Observer returns the observer recording the runs of the job, including the
+// age of the oldest row left in every table
+func (m *Metrics) Observer(job string) archiver.OldestRowObserver {
+	return &observer{metrics: m, job: job}
+}
+
+// Finished records the outcome of a run of the job
+func (m *Metrics) Finished(job string, err error) {
+	if err != nil {
+		m.errors.WithLabelValues(job).Inc()
+		return
+	}
+
+	m.errors.WithLabelValues(job).Add(0)
+	m.lastSuccess.WithLabelValues(job).SetToCurrentTime()
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile writes the metrics to path in the format of the node_exporter
+// textfile collector. The file is replaced atomically.
+func (m *Metrics) WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, m.registry)
+}
+
+type observer struct {
+	metrics *Metrics
+	job     string
+}
+
+func (o *observer) Archived(table string, rows int, bytes int64, duration time.Duration) {
+	o.metrics.rowsArchived.WithLabelValues(o.job, table).Add(float64(rows))
+	o.metrics.bytesWritten.WithLabelValues(o.job, table).Add(float64(bytes))
+	o.metrics.batchDuration.WithLabelValues(o.job, table).Observe(duration.Seconds())
+}
+
+func (o *observer) Deleted(table string, rows int64, duration time.Duration) {
+	o.metrics.rowsDeleted.WithLabelValues(o.job, table).Add(float64(rows))
+	o.metrics.deleteDuration.WithLabelValues(o.job, table).Observe(duration.Seconds())
+}
+
+func (o *observer) OldestRow(table string, age time.Duration) {
+	o.metrics.oldestRowAge.WithLabelValues(o.job, table).Set(age.Seconds())
+}
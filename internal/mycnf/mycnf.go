@@ -0,0 +1,269 @@
+// Package mycnf reads MySQL option files (my.cnf) the same way the mysql
+// client does, so archi can reuse credentials that already exist on a host.
+package mycnf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Options holds the values collected from the requested groups. Keys are
+// normalised to lower case with dashes replaced by underscores, so
+// "ssl-ca" and "ssl_ca" end up under the same key.
+type Options map[string]string
+
+// maxIncludeDepth guards against include loops between option files.
+const maxIncludeDepth = 10
+
+// DefaultFiles returns the option files the mysql client reads when no
+// --defaults-file is given, in the order they are read.
+func DefaultFiles() []string {
+	files := []string{"/etc/my.cnf", "/etc/mysql/my.cnf"}
+
+	if home := os.Getenv("MYSQL_HOME"); home != "" {
+		files = append(files, filepath.Join(home, "my.cnf"))
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".my.cnf"))
+	}
+
+	return files
+}
+
+// Load reads the options of the given groups. When defaultsFile is set only
+// that file is read and it must exist, otherwise the DefaultFiles that exist
+// are read in order. Like the mysql client, options are taken in the order
+// they appear in, whatever their group, and an included file is read where
+// its !include is: an option overrides those before it.
+func Load(defaultsFile string, groups []string) (Options, error) {
+	opts := Options{}
+
+	if defaultsFile != "" {
+		if err := opts.readFile(defaultsFile, groups, 0); err != nil {
+			return nil, err
+		}
+
+		return opts, nil
+	}
+
+	for _, file := range DefaultFiles() {
+		err := opts.readFile(file, groups, 0)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+// Get returns the value of the option and whether it was present.
+func (o Options) Get(key string) (string, bool) {
+	v, ok := o[normaliseKey(key)]
+	return v, ok
+}
+
+func (o Options) readFile(path string, groups []string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: too many nested includes", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	current := ""
+	lineNo := 0
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!include ") {
+			include := resolvePath(path, strings.TrimSpace(strings.TrimPrefix(line, "!include ")))
+			if err := o.readFile(include, groups, depth+1); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(line, "!includedir ") {
+			dir := resolvePath(path, strings.TrimSpace(strings.TrimPrefix(line, "!includedir ")))
+			if err := o.readDir(dir, groups, depth+1); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return fmt.Errorf("%s:%d: malformed group header", path, lineNo)
+			}
+
+			current = strings.ToLower(strings.TrimSpace(line[1:end]))
+			continue
+		}
+
+		if !slices.Contains(groups, current) {
+			continue
+		}
+
+		key, value, err := parseOption(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNo, err)
+		}
+
+		o[key] = value
+	}
+
+	return scanner.Err()
+}
+
+func (o Options) readDir(dir string, groups []string, depth int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".cnf" {
+			continue
+		}
+
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := o.readFile(filepath.Join(dir, name), groups, depth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func resolvePath(from string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(filepath.Dir(from), path)
+}
+
+func parseOption(line string) (string, string, error) {
+	key, value, hasValue := strings.Cut(line, "=")
+
+	key = normaliseKey(key)
+	if key == "" {
+		return "", "", fmt.Errorf("missing option name")
+	}
+
+	if !hasValue {
+		return key, "", nil
+	}
+
+	value = strings.TrimSpace(value)
+
+	if len(value) > 0 && (value[0] == '"' || value[0] == '\'') {
+		end := closingQuote(value)
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quote in value of %s", key)
+		}
+
+		if rest := strings.TrimSpace(value[end+1:]); rest != "" && rest[0] != '#' {
+			return "", "", fmt.Errorf("unexpected %q after the quoted value of %s", rest, key)
+		}
+
+		return key, unescape(value[1:end]), nil
+	}
+
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+
+	if i := strings.Index(value, "\t#"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+
+	return key, unescape(value), nil
+}
+
+// closingQuote returns the index of the quote closing the one value starts
+// with, skipping escaped quotes, or -1 when there is none
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case value[0]:
+			return i
+		}
+	}
+
+	return -1
+}
+
+// normaliseKey also drops the loose- prefix, which only tells the mysql
+// client to ignore options it doesn't know
+func normaliseKey(key string) string {
+	key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "-", "_")
+
+	return strings.TrimPrefix(key, "loose_")
+}
+
+// unescape handles the escape sequences the mysql client accepts in option values.
+func unescape(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		switch value[i] {
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 's':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,247 @@
+package mycnf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes the files to a temporary directory and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func load(t *testing.T, path string, groups ...string) Options {
+	t.Helper()
+
+	opts, err := Load(path, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return opts
+}
+
+func expect(t *testing.T, opts Options, key string, want string) {
+	t.Helper()
+
+	got, ok := opts.Get(key)
+	if !ok {
+		t.Errorf("%s is missing, want %q", key, want)
+		return
+	}
+
+	if got != want {
+		t.Errorf("%s = %q, want %q", key, got, want)
+	}
+}
+
+func TestLoadGroups(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"my.cnf": `
+# comment
+; another comment
+[client]
+user = reader
+port=3307
+
+[mysql]
+user = mysql-only
+prompt = mysql>
+
+[mysqldump]
+user = dumper
+
+[Archi]
+ssl-ca = /etc/ca.pem
+`})
+
+	opts := load(t, filepath.Join(dir, "my.cnf"), "client", "archi")
+
+	expect(t, opts, "user", "reader")
+	expect(t, opts, "port", "3307")
+	expect(t, opts, "ssl_ca", "/etc/ca.pem")
+	expect(t, opts, "SSL-CA", "/etc/ca.pem")
+
+	if _, ok := opts.Get("prompt"); ok {
+		t.Error("the [mysql] group was read")
+	}
+
+	opts = load(t, filepath.Join(dir, "my.cnf"), "client", "mysql")
+	expect(t, opts, "user", "mysql-only")
+	expect(t, opts, "prompt", "mysql>")
+}
+
+func TestLoadTakesOptionsInTheOrderTheyAppear(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"my.cnf": `
+[archi]
+user = archi
+password = archi-secret
+
+[client]
+user = client
+`})
+
+	opts := load(t, filepath.Join(dir, "my.cnf"), "client", "archi")
+
+	// like the mysql client, the later [client] wins over the earlier
+	// [archi] whatever the order of the groups
+	expect(t, opts, "user", "client")
+	expect(t, opts, "password", "archi-secret")
+}
+
+func TestLoadValues(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"my.cnf": `
+[client]
+double = "with # hash"
+single = 'single quoted'
+escaped = "say \"hi\""
+trailing = "quoted" # comment
+empty = ""
+comment = plain # comment
+tab = plain	# comment
+hash = a#b
+escapes = a\tb\sc\\d
+spaces   =   padded
+flag
+loose-ssl-mode = REQUIRED
+loose_ssl_key = /etc/key.pem
+`})
+
+	opts := load(t, filepath.Join(dir, "my.cnf"), "client")
+
+	expect(t, opts, "double", "with # hash")
+	expect(t, opts, "single", "single quoted")
+	expect(t, opts, "escaped", `say "hi"`)
+	expect(t, opts, "trailing", "quoted")
+	expect(t, opts, "empty", "")
+	expect(t, opts, "comment", "plain")
+	expect(t, opts, "tab", "plain")
+	expect(t, opts, "hash", "a#b")
+	expect(t, opts, "escapes", `a`+"\t"+`b c\d`)
+	expect(t, opts, "spaces", "padded")
+	expect(t, opts, "flag", "")
+	expect(t, opts, "ssl_mode", "REQUIRED")
+	expect(t, opts, "ssl-key", "/etc/key.pem")
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := map[string]string{
+		"unterminated quote":      "[client]\npassword = \"secret\n",
+		"escaped closing quote":   "[client]\npassword = 'secret\\'\n",
+		"text after a quote":      "[client]\npassword = \"secret\" more\n",
+		"malformed group":         "[client\nuser = a\n",
+		"missing option name":     "[client]\n= value\n",
+		"missing included file":   "[client]\n!include missing.cnf\n",
+		"missing included folder": "[client]\n!includedir missing.d\n",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"my.cnf": content})
+
+			if opts, err := Load(filepath.Join(dir, "my.cnf"), []string{"client"}); err == nil {
+				t.Errorf("Load = %v, want an error", opts)
+			}
+		})
+	}
+}
+
+func TestLoadInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"my.cnf": `
+[client]
+user = before
+password = before
+!include included.cnf
+password = after
+`,
+		"included.cnf": `
+[client]
+user = included
+password = included
+host = db.example.com
+`,
+	})
+
+	opts := load(t, filepath.Join(dir, "my.cnf"), "client")
+
+	// the included file is read where its !include is, so it overrides
+	// the lines before and the lines after override it
+	expect(t, opts, "user", "included")
+	expect(t, opts, "password", "after")
+	expect(t, opts, "host", "db.example.com")
+}
+
+func TestLoadIncludeKeepsTheGroup(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"my.cnf": `
+[mysqldump]
+!include included.cnf
+user = dumper
+`,
+		"included.cnf": `
+[client]
+user = included
+`,
+	})
+
+	opts := load(t, filepath.Join(dir, "my.cnf"), "client")
+
+	// the group of the included file ends with it, user = dumper is still
+	// in [mysqldump]
+	expect(t, opts, "user", "included")
+}
+
+func TestLoadIncludeDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"my.cnf": `
+[client]
+user = before
+port = 3306
+!includedir conf.d
+port = 3310
+`,
+		"conf.d/20-user.cnf": "[client]\nuser = second\n",
+		"conf.d/10-user.cnf": "[client]\nuser = first\nhost = first\n",
+		"conf.d/30-user.txt": "[client]\nuser = ignored\n",
+		"conf.d/sub/40.cnf":  "[client]\nuser = nested\n",
+	})
+
+	opts := load(t, filepath.Join(dir, "my.cnf"), "client")
+
+	// the files of the directory are read by name
+	expect(t, opts, "user", "second")
+	expect(t, opts, "host", "first")
+	expect(t, opts, "port", "3310")
+}
+
+func TestLoadIncludeLoop(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.cnf": "[client]\n!include b.cnf\n",
+		"b.cnf": "[client]\n!include a.cnf\n",
+	})
+
+	if _, err := Load(filepath.Join(dir, "a.cnf"), []string{"client"}); err == nil {
+		t.Error("Load of files including each other succeeded")
+	}
+}
+
+func TestLoadDefaultsFileMustExist(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.cnf"), []string{"client"}); err == nil {
+		t.Error("Load of a missing defaults file succeeded")
+	}
+}
@@ -0,0 +1,249 @@
+// Package progress reports how far an archive run got. On a terminal it
+// redraws a line per table, otherwise it logs the progress periodically.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogInterval is how often the progress is logged when it isn't drawn
+var LogInterval = 10 * time.Second
+
+// redrawInterval limits how often the live display is redrawn
+const redrawInterval = 200 * time.Millisecond
+
+type Progress struct {
+	mu sync.Mutex
+
+	out  io.Writer
+	live bool
+
+	startedAt time.Time
+	tables    []string
+	stats     map[string]*tableStats
+
+	drawn    int
+	lastDraw time.Time
+	lastLog  time.Time
+}
+
+type tableStats struct {
+	planned int64
+	hasPlan bool
+	rows    int64
+	bytes   int64
+}
+
+// New returns the progress of a run. With live set the progress is drawn to
+// out, which should be a terminal, otherwise it is logged with slog.
+func New(out io.Writer, live bool) *Progress {
+	now := time.Now()
+
+	return &Progress{
+		out:     out,
+		live:    live,
+		lastLog: now,
+		stats:   map[string]*tableStats{},
+	}
+}
+
+func (p *Progress) table(name string) *tableStats {
+	// the clock starts with the first table of the run
+	if p.startedAt.IsZero() {
+		p.startedAt = time.Now()
+	}
+
+	stats, ok := p.stats[name]
+	if !ok {
+		stats = &tableStats{}
+		p.stats[name] = stats
+		p.tables = append(p.tables, name)
+	}
+
+	return stats
+}
+
+func (p *Progress) Planned(table string, rows int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.table(table)
+	stats.planned = rows
+	stats.hasPlan = true
+}
+
+func (p *Progress) Archived(table string, rows int, bytes int64, duration time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.table(table)
+	stats.rows += int64(rows)
+	stats.bytes += bytes
+
+	p.report(false)
+}
+
+func (p *Progress) Deleted(table string, rows int64, duration time.Duration) {}
+
+// Finish reports the final progress and leaves the display on the screen
+func (p *Progress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.report(true)
+}
+
+// Write writes p to the output under the live display, so log lines don't
+// get mixed up with it
+func (p *Progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.live {
+		return p.out.Write(b)
+	}
+
+	p.clear()
+
+	n, err := p.out.Write(b)
+	if err != nil {
+		return n, err
+	}
+
+	p.draw()
+
+	return n, nil
+}
+
+func (p *Progress) report(final bool) {
+	now := time.Now()
+
+	if p.live {
+		if final || now.Sub(p.lastDraw) >= redrawInterval {
+			p.clear()
+			p.draw()
+			p.lastDraw = now
+		}
+
+		return
+	}
+
+	if final || now.Sub(p.lastLog) >= LogInterval {
+		for _, name := range p.tables {
+			p.log(name, now)
+		}
+
+		p.lastLog = now
+	}
+}
+
+func (p *Progress) log(name string, now time.Time) {
+	stats := p.stats[name]
+	rate := stats.rate(now.Sub(p.startedAt))
+
+	args := []any{"table", name, "rows", stats.rows, "bytes", stats.bytes, "rowsPerSecond", int64(rate)}
+	if stats.hasPlan {
+		args = append(args, "total", stats.planned, "percent", stats.percent())
+
+		if eta, ok := stats.eta(rate); ok {
+			args = append(args, "eta", eta.Round(time.Second).String())
+		}
+	}
+
+	slog.Info("progress", args...)
+}
+
+func (p *Progress) clear() {
+	if p.drawn == 0 {
+		return
+	}
+
+	// move to the first line of the display and clear it to the end
+	fmt.Fprintf(p.out, "\033[%dF\033[J", p.drawn)
+	p.drawn = 0
+}
+
+func (p *Progress) draw() {
+	now := time.Now()
+
+	width := 0
+	for _, name := range p.tables {
+		width = max(width, len(name))
+	}
+
+	var b strings.Builder
+	for _, name := range p.tables {
+		stats := p.stats[name]
+		rate := stats.rate(now.Sub(p.startedAt))
+
+		fmt.Fprintf(&b, "%-*s  ", width, name)
+
+		if stats.hasPlan {
+			fmt.Fprintf(&b, "%d/%d rows %3.0f%%", stats.rows, stats.planned, stats.percent())
+		} else {
+			fmt.Fprintf(&b, "%d rows", stats.rows)
+		}
+
+		fmt.Fprintf(&b, "  %.0f rows/s  %s", rate, FormatBytes(stats.bytes))
+
+		if eta, ok := stats.eta(rate); ok && stats.hasPlan {
+			fmt.Fprintf(&b, "  ETA %s", eta.Round(time.Second))
+		}
+
+		b.WriteString("\n")
+	}
+
+	io.WriteString(p.out, b.String())
+	p.drawn = len(p.tables)
+}
+
+func (s *tableStats) rate(elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(s.rows) / elapsed.Seconds()
+}
+
+func (s *tableStats) percent() float64 {
+	if s.planned == 0 {
+		return 100
+	}
+
+	return min(100, float64(s.rows)*100/float64(s.planned))
+}
+
+func (s *tableStats) eta(rate float64) (time.Duration, bool) {
+	if !s.hasPlan || rate <= 0 {
+		return 0, false
+	}
+
+	left := s.planned - s.rows
+	if left <= 0 {
+		return 0, true
+	}
+
+	return time.Duration(float64(left) / rate * float64(time.Second)), true
+}
+
+// FormatBytes formats n bytes in binary units: 512 B, 1.5 KiB, 2.0 GiB
+func FormatBytes(n int64) string {
+	const unit = 1024
+
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,115 @@
+// Package scheduler runs functions on cron schedules inside one process. An
+// entry is never started while its previous run is still going.
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+type Entry struct {
+	Name     string
+	Schedule cron.Schedule
+	Run      func(ctx context.Context)
+}
+
+type Scheduler struct {
+	runCtx context.Context
+
+	mu      sync.Mutex
+	running map[string]bool
+	stop    context.CancelFunc
+	loops   sync.WaitGroup
+	runs    sync.WaitGroup
+}
+
+// New returns a scheduler whose runs get runCtx. Cancelling runCtx asks the
+// runs in progress to stop; it does not stop the scheduling itself.
+func New(runCtx context.Context) *Scheduler {
+	return &Scheduler{
+		runCtx:  runCtx,
+		running: map[string]bool{},
+	}
+}
+
+// Replace stops scheduling the current entries and starts scheduling the
+// given ones. Runs in progress are left alone, and an entry with the same
+// name as a running one still waits for it to finish.
+func (s *Scheduler) Replace(entries []Entry) {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.stop()
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+	s.mu.Unlock()
+
+	s.loops.Wait()
+
+	for _, entry := range entries {
+		s.loops.Add(1)
+		go s.loop(ctx, entry)
+	}
+}
+
+// Stop stops scheduling and waits for the runs in progress to return
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.stop()
+	}
+	s.mu.Unlock()
+
+	s.loops.Wait()
+	s.runs.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, entry Entry) {
+	defer s.loops.Done()
+
+	for {
+		next := entry.Schedule.Next(time.Now())
+		slog.Debug("next run scheduled", "job", entry.Name, "at", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.start(entry)
+	}
+}
+
+func (s *Scheduler) start(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running[entry.Name] {
+		slog.Warn("previous run is still going, skipping this run", "job", entry.Name)
+		return
+	}
+
+	s.running[entry.Name] = true
+	s.runs.Add(1)
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, entry.Name)
+			s.mu.Unlock()
+
+			s.runs.Done()
+		}()
+
+		entry.Run(s.runCtx)
+	}()
+}